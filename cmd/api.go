@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/yockii/wangshu-manager/internal/auth"
+)
+
+// apiV1Prefix is the prefix of the versioned API. Routes under /api/ without
+// it are deprecated aliases that keep their original response bodies.
+const apiV1Prefix = "v1/"
+
+// Error codes of the versioned API, found in the "code" field of the error
+// envelope. Clients should branch on these rather than on messages.
+const (
+	codeBadRequest       = "bad_request"
+	codeUnauthorized     = "unauthorized"
+	codeForbidden        = "forbidden"
+	codeCSRFFailed       = "csrf_failed"
+	codeOriginNotAllowed = "origin_not_allowed"
+	codeHostNotAllowed   = "host_not_allowed"
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeConflict         = "conflict"
+	codeValidationFailed = "validation_failed"
+	codeRateLimited      = "rate_limited"
+	codeUpstreamError    = "upstream_error"
+	codeUnavailable      = "unavailable"
+	codeInternalError    = "internal_error"
+)
+
+// apiEnvelope is the body of every versioned API response except file
+// downloads: data on success, error otherwise.
+type apiEnvelope struct {
+	Data  interface{} `json:"data,omitempty"`
+	Error *apiError   `json:"error,omitempty"`
+}
+
+type apiError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+	// RequestID is the ID of the request, also sent in the X-Request-ID
+	// header, to quote when reporting the error.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type apiHandler func(s *Server, w http.ResponseWriter, r *http.Request)
+
+// apiOperation is one method of a route. An empty role means the operation
+// needs no authentication.
+type apiOperation struct {
+	role    auth.Role
+	handler apiHandler
+}
+
+// apiRoute is an API path relative to /api/ or /api/v1/. Segments written
+// as {name} match any single segment, which handlers read with
+// r.PathValue(name).
+type apiRoute struct {
+	pattern    string
+	operations map[string]apiOperation
+}
+
+// apiRoutes lists every API route. It is filled in init so that handlers
+// may refer back to it.
+var apiRoutes []apiRoute
+
+func init() {
+	apiRoutes = []apiRoute{
+		{"login", map[string]apiOperation{
+			"POST": {"", (*Server).handleLogin},
+		}},
+		{"logout", map[string]apiOperation{
+			"POST": {auth.RoleViewer, (*Server).handleLogout},
+		}},
+		{"session", map[string]apiOperation{
+			"GET": {auth.RoleViewer, (*Server).handleSession},
+		}},
+		{"sessions", map[string]apiOperation{
+			"GET": {auth.RoleViewer, (*Server).handleSessions},
+		}},
+		{"tasks", map[string]apiOperation{
+			"GET": {auth.RoleViewer, (*Server).handleTasks},
+		}},
+		{"cron", map[string]apiOperation{
+			"GET": {auth.RoleViewer, (*Server).handleCron},
+		}},
+		{"config", map[string]apiOperation{
+			"GET": {auth.RoleAdmin, (*Server).getConfig},
+			"PUT": {auth.RoleAdmin, (*Server).putConfig},
+		}},
+		{"config/diff", map[string]apiOperation{
+			"POST": {auth.RoleAdmin, (*Server).handleConfigDiff},
+		}},
+		{"config/schema", map[string]apiOperation{
+			"GET": {auth.RoleViewer, (*Server).handleConfigSchema},
+		}},
+		{"config/channel-types", map[string]apiOperation{
+			"GET": {auth.RoleViewer, (*Server).handleChannelTypes},
+		}},
+		{"config/export", map[string]apiOperation{
+			"GET": {auth.RoleAdmin, (*Server).handleConfigExport},
+		}},
+		{"config/import", map[string]apiOperation{
+			"POST": {auth.RoleAdmin, (*Server).handleConfigImport},
+		}},
+		{"instance", map[string]apiOperation{
+			"GET":  {auth.RoleViewer, (*Server).getInstanceStatus},
+			"POST": {auth.RoleOperator, (*Server).handleInstanceAction},
+		}},
+		{"listeners", map[string]apiOperation{
+			"GET": {auth.RoleViewer, (*Server).handleListeners},
+		}},
+		{"audit", map[string]apiOperation{
+			"GET": {auth.RoleAdmin, (*Server).handleAudit},
+		}},
+		{"providers/{name}/test", map[string]apiOperation{
+			"POST": {auth.RoleAdmin, (*Server).testProvider},
+		}},
+		{"providers/{name}/models", map[string]apiOperation{
+			"GET": {auth.RoleAdmin, (*Server).listProviderModels},
+		}},
+		{"tokens", map[string]apiOperation{
+			"GET":  {auth.RoleAdmin, (*Server).listTokens},
+			"POST": {auth.RoleAdmin, (*Server).createToken},
+		}},
+		{"tokens/{id}", map[string]apiOperation{
+			"DELETE": {auth.RoleAdmin, (*Server).revokeToken},
+		}},
+		{"users", map[string]apiOperation{
+			"GET":  {auth.RoleAdmin, (*Server).listUsers},
+			"POST": {auth.RoleAdmin, (*Server).createUser},
+		}},
+		{"users/{name}", map[string]apiOperation{
+			"PUT":    {auth.RoleAdmin, (*Server).updateUser},
+			"DELETE": {auth.RoleAdmin, (*Server).deleteUser},
+		}},
+		{openAPIPath, map[string]apiOperation{
+			"GET": {"", (*Server).handleOpenAPI},
+		}},
+	}
+}
+
+// match reports whether path matches the route and sets the values of its
+// {name} segments on r.
+func (rt *apiRoute) match(r *http.Request, path string) bool {
+	want := strings.Split(rt.pattern, "/")
+	got := strings.Split(path, "/")
+	if len(want) != len(got) {
+		return false
+	}
+	for i, seg := range want {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			if got[i] == "" {
+				return false
+			}
+			continue
+		}
+		if seg != got[i] {
+			return false
+		}
+	}
+	for i, seg := range want {
+		if strings.HasPrefix(seg, "{") {
+			r.SetPathValue(strings.Trim(seg, "{}"), got[i])
+		}
+	}
+	return true
+}
+
+// operation returns the operation for method. HEAD requests are served by
+// the GET operation; net/http drops the body.
+func (rt *apiRoute) operation(method string) (apiOperation, bool) {
+	if method == "HEAD" {
+		method = "GET"
+	}
+	op, ok := rt.operations[method]
+	return op, ok
+}
+
+// allow returns the value of the Allow header for the route.
+func (rt *apiRoute) allow() string {
+	methods := make([]string, 0, len(rt.operations)+2)
+	for method := range rt.operations {
+		methods = append(methods, method)
+	}
+	if _, ok := rt.operations["GET"]; ok {
+		methods = append(methods, "HEAD")
+	}
+	methods = append(methods, "OPTIONS")
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+type apiVersionKey struct{}
+
+// withAPIVersion strips the version prefix from path and records in the
+// request whether the versioned API was called. Calls to the unversioned
+// aliases are marked deprecated, except for the OpenAPI document.
+func withAPIVersion(w http.ResponseWriter, r *http.Request, path string) (*http.Request, string) {
+	if rest, ok := strings.CutPrefix(path, apiV1Prefix); ok {
+		return r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, true)), rest
+	}
+	if path == openAPIPath {
+		return r, path
+	}
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", "</api/"+apiV1Prefix+path+`>; rel="successor-version"`)
+	return r, path
+}
+
+// isAPIV1 reports whether r was made to the versioned API. Middleware that
+// runs before the API router has marked r goes by the path.
+func isAPIV1(r *http.Request) bool {
+	v1, _ := r.Context().Value(apiVersionKey{}).(bool)
+	return v1 || strings.HasPrefix(r.URL.Path, "/api/"+apiV1Prefix)
+}
+
+// writeJSON writes data with the given status, wrapped in the envelope on
+// the versioned API.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if isAPIV1(r) {
+		json.NewEncoder(w).Encode(apiEnvelope{Data: data})
+		return
+	}
+	json.NewEncoder(w).Encode(data)
+}
+
+// writeError writes an error envelope on the versioned API and the plain
+// text message the unversioned routes always answered with otherwise.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if !isAPIV1(r) {
+		httpError(w, r, message, status)
+		return
+	}
+	writeErrorEnvelope(w, r, status, &apiError{Code: code, Message: message})
+}
+
+// httpError is http.Error with the request ID appended to the message, for
+// routes that answer errors in plain text.
+func httpError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	if id := requestID(r); id != "" {
+		message += " (request ID: " + id + ")"
+	}
+	http.Error(w, message, status)
+}
+
+// writeErrorDetails is writeError for errors that carry a JSON body, such as
+// validation results. The unversioned routes get that body on its own.
+func writeErrorDetails(w http.ResponseWriter, r *http.Request, status int, code, message string, details interface{}) {
+	if !isAPIV1(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(details)
+		return
+	}
+	writeErrorEnvelope(w, r, status, &apiError{Code: code, Message: message, Details: details})
+}
+
+func writeErrorEnvelope(w http.ResponseWriter, r *http.Request, status int, apiErr *apiError) {
+	apiErr.RequestID = requestID(r)
+	h := w.Header()
+	h.Del("Content-Disposition")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiEnvelope{Error: apiErr})
+}
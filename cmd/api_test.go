@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yockii/wangshu-manager/internal/auth"
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+// TestRouteRoles checks that every route refuses callers whose role is
+// below the one it requires.
+func TestRouteRoles(t *testing.T) {
+	for _, role := range []auth.Role{auth.RoleViewer, auth.RoleOperator} {
+		t.Run(string(role), func(t *testing.T) {
+			s := newTestServer(t, map[string]config.ChannelConfig{"web": webTestChannel("channel-token", false)})
+			ts := serveListener(t, s, "web")
+			_, secret, err := s.tokens.Create("test", role, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, route := range apiRoutes {
+				path := "/api/v1/" + strings.NewReplacer("{name}", "x", "{id}", "x").Replace(route.pattern)
+				for method, op := range route.operations {
+					if op.role == "" || role.Allows(op.role) {
+						continue
+					}
+					if got := apiStatus(t, ts, method, path, secret); got != http.StatusForbidden {
+						t.Errorf("%s %s with %s token = %d, want %d", method, path, role, got, http.StatusForbidden)
+					}
+				}
+			}
+		})
+	}
+}
+
+func dialStatus(t *testing.T, ts *httptest.Server, path, token string) int {
+	t.Helper()
+	header := http.Header{}
+	if token != "" {
+		header.Set("Authorization", token)
+	}
+	dialer := websocket.Dialer{HandshakeTimeout: 5 * time.Second}
+	conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+path, header)
+	if conn != nil {
+		conn.Close()
+	}
+	if resp == nil {
+		t.Fatalf("dial %s: %v", path, err)
+	}
+	return resp.StatusCode
+}
+
+func TestWangshuWebSocketRequiresBackend(t *testing.T) {
+	s := newTestServer(t, map[string]config.ChannelConfig{"web": webTestChannel("channel-token", false)})
+	s.adminToken = "admin-token"
+	s.backendToken = "backend-token"
+	ts := serveListener(t, s, "web")
+	_, viewer, err := s.tokens.Create("viewer", auth.RoleViewer, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, operator, err := s.tokens.Create("operator", auth.RoleOperator, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"backend token", "backend-token", http.StatusSwitchingProtocols},
+		{"backend bearer token", "Bearer backend-token", http.StatusSwitchingProtocols},
+		{"admin token", "admin-token", http.StatusSwitchingProtocols},
+		{"channel token", "channel-token", http.StatusForbidden},
+		{"operator API token", operator, http.StatusForbidden},
+		{"viewer API token", viewer, http.StatusForbidden},
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "wrong", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dialStatus(t, ts, "/ws", tt.token); got != tt.status {
+				t.Errorf("dial /ws = %d, want %d", got, tt.status)
+			}
+		})
+	}
+}
+
+func TestWangshuWebSocketChannelTokenWithoutBackendToken(t *testing.T) {
+	s := newTestServer(t, map[string]config.ChannelConfig{
+		"web":   webTestChannel("channel-token", false),
+		"other": webTestChannel("other-token", false),
+		"open":  webTestChannel("", true),
+	})
+	web := serveListener(t, s, "web")
+	open := serveListener(t, s, "open")
+
+	tests := []struct {
+		name   string
+		ts     *httptest.Server
+		token  string
+		status int
+	}{
+		{"own channel token", web, "channel-token", http.StatusSwitchingProtocols},
+		{"own channel bearer token", web, "Bearer channel-token", http.StatusSwitchingProtocols},
+		{"other channel token", web, "other-token", http.StatusUnauthorized},
+		{"no token", web, "", http.StatusUnauthorized},
+		{"no_auth listener", open, "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.limiter = newClientLimiter()
+			if got := dialStatus(t, tt.ts, "/ws", tt.token); got != tt.status {
+				t.Errorf("dial /ws = %d, want %d", got, tt.status)
+			}
+		})
+	}
+}
+
+func TestRequestToken(t *testing.T) {
+	tests := []struct {
+		header string
+		query  string
+		want   string
+	}{
+		{"", "", ""},
+		{"", "query-token", "query-token"},
+		{"plain-token", "query-token", "plain-token"},
+		{"Bearer bearer-token", "", "bearer-token"},
+		{"bearer bearer-token", "", "bearer-token"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/v1/listeners?token="+tt.query, nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		if got := requestToken(r); got != tt.want {
+			t.Errorf("requestToken(%q, %q) = %q, want %q", tt.header, tt.query, got, tt.want)
+		}
+	}
+}
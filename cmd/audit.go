@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yockii/wangshu-manager/internal/auth"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// AuditEntry records who made a change through the API.
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"`
+	Role   auth.Role `json:"role"`
+	Action string    `json:"action"`
+	Status int       `json:"status"`
+	Remote string    `json:"remote"`
+}
+
+// auditLog appends entries to a JSON lines file next to the other manager
+// files.
+type auditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+func auditLogPath(wangshuPath string) string {
+	return managerDataPath(wangshuPath, "audit.log")
+}
+
+// Record writes an entry for request r made by p that ended with status.
+// The token query parameter is left out of the recorded action.
+func (a *auditLog) Record(r *http.Request, p principal, status int) {
+	query := r.URL.Query()
+	query.Del("token")
+	action := r.Method + " " + r.URL.Path
+	if len(query) > 0 {
+		action += "?" + query.Encode()
+	}
+
+	entry := AuditEntry{
+		Time:   time.Now().UTC(),
+		User:   p.Name,
+		Role:   p.Role,
+		Action: action,
+		Status: status,
+		Remote: r.RemoteAddr,
+	}
+	slog.Info("Audit", "user", entry.User, "action", entry.Action, "status", entry.Status)
+
+	if err := a.append(entry); err != nil {
+		slog.Error("Failed to write audit log", "error", err)
+	}
+}
+
+func (a *auditLog) append(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(a.path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Recent returns up to limit entries, newest first.
+func (a *auditLog) Recent(limit int) ([]AuditEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entries := []AuditEntry{}
+	f, err := os.Open(a.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+		if len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	limit := defaultAuditLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = min(n, maxAuditLimit)
+	}
+
+	entries, err := s.audit.Recent(limit)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to read audit log: %v", err))
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"entries": entries,
+	})
+}
+
+// statusRecorder remembers the status code a handler wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
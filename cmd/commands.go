@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+const defaultConfigPath = "~/.wangshu/config.json"
+
+func runConvert(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: wangshu-manager convert <source> <target>")
+	}
+
+	if err := config.ConvertConfig(args[0], args[1]); err != nil {
+		return err
+	}
+
+	slog.Info("Config converted", "source", args[0], "target", args[1])
+	return nil
+}
+
+func runInit(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	force := fs.Bool("force", false, "overwrite an existing config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfgPath := defaultConfigPath
+	if fs.NArg() > 0 {
+		cfgPath = fs.Arg(0)
+	}
+
+	return initConfig(cfgPath, *force)
+}
+
+func initConfig(cfgPath string, force bool) error {
+	cfg, err := config.InitConfig(cfgPath, force)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Config initialized", "path", cfgPath)
+	fmt.Println("Config written to", cfgPath)
+	for _, url := range channelURLs(cfg) {
+		fmt.Println("Open", url)
+	}
+	fmt.Println("Sign in with the channel token from the config file; API clients exchange it for a session with POST /api/v1/login or send it in the Authorization header.")
+	fmt.Println("Channel tokens can chat and control the instance. To change the config, add an admin user or token:")
+	fmt.Println("  wangshu-manager user add -name admin -role admin", cfgPath)
+	fmt.Println("  wangshu-manager token create -name admin -role admin", cfgPath)
+	return nil
+}
+
+// channelURLs returns the addresses of the enabled web channels of cfg.
+// Tokens are left out: in a URL they end up in shell history, logs and
+// Referer headers.
+func channelURLs(cfg *config.Config) []string {
+	var urls []string
+	for _, channel := range cfg.Channels {
+		web, ok := channel.Web()
+		if !ok || !channel.Enabled {
+			continue
+		}
+		addr := web.Address()
+		if strings.HasPrefix(addr, ":") {
+			addr = "localhost" + addr
+		}
+		urls = append(urls, fmt.Sprintf("http://%s/", addr))
+	}
+	sort.Strings(urls)
+	return urls
+}
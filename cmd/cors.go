@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+const (
+	corsAllowMethods  = "GET, POST, PUT, DELETE, OPTIONS"
+	corsAllowHeaders  = "Authorization, Content-Type, X-CSRF-Token, X-Bundle-Passphrase"
+	corsExposeHeaders = "Content-Disposition"
+	corsMaxAge        = "600"
+)
+
+// originAllowed reports whether a page from the request's origin may use the
+// listener that received r: requests without an Origin header, from the
+// listener's own origin or from one of its allowed_origins are accepted.
+func (s *Server) originAllowed(r *http.Request) bool {
+	if s.sameOrigin(r) {
+		return true
+	}
+	origin, err := config.NormalizeOrigin(r.Header.Get("Origin"))
+	if err != nil {
+		return false
+	}
+
+	s.cfgMu.RLock()
+	channel, ok := s.webChannels[listenerChannel(r)]
+	s.cfgMu.RUnlock()
+	if !ok {
+		return false
+	}
+	web, _ := channel.Web()
+	for _, allowed := range web.AllowedOrigins {
+		if normalized, err := config.NormalizeOrigin(allowed); err == nil && normalized == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOrigin reports whether r has no Origin header or comes from a page of
+// the listener itself: same scheme, and a host the listener answers to.
+func (s *Server) sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return u.Scheme == scheme && strings.EqualFold(u.Host, r.Host) && s.hostAllowed(r, u.Host)
+}
+
+// handleCORS adds CORS headers for API requests from allowed origins and
+// answers preflight requests. It returns false when the request is done:
+// a preflight, or a cross-origin request from an origin that is not allowed.
+func (s *Server) handleCORS(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || s.sameOrigin(r) {
+		return true
+	}
+	w.Header().Add("Vary", "Origin")
+	if !s.originAllowed(r) {
+		slog.Warn("Rejected request from disallowed origin", "origin", origin, "path", r.URL.Path, "remote", r.RemoteAddr)
+		writeError(w, r, http.StatusForbidden, codeOriginNotAllowed, "Forbidden: origin not allowed")
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+		w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+		w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+		w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+	w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+	return true
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Checks of /readyz that only affect readiness when named in the require
+// query parameter, e.g. /readyz?require=instance,websocket.
+const (
+	readyCheckInstance  = "instance"
+	readyCheckWebSocket = "websocket"
+
+	// instanceCheckTTL is how long the outcome of looking for the wangshu
+	// process is reused, so probes cannot make the manager run ps at will.
+	instanceCheckTTL = 5 * time.Second
+)
+
+// readyCheck is the outcome of one readiness check.
+type readyCheck struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Required bool   `json:"required"`
+	Error    string `json:"error,omitempty"`
+}
+
+// handleHealthz reports that the manager is alive. It needs no
+// authentication.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if !healthMethodAllowed(w, r) {
+		return
+	}
+	writeHealth(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+	})
+}
+
+// handleReadyz reports whether the manager is ready to serve: the config is
+// loaded, it is not shutting down and every configured listener is bound.
+// Whether the wangshu instance is running and connected over the WebSocket
+// is always reported, but only counts when required. It needs no
+// authentication, so it reports which checks failed but not why; the details
+// are logged and listed by /api/v1/listeners.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !healthMethodAllowed(w, r) {
+		return
+	}
+
+	required := make(map[string]bool)
+	for _, name := range strings.Split(r.URL.Query().Get("require"), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if name != readyCheckInstance && name != readyCheckWebSocket {
+			httpError(w, r, fmt.Sprintf("unknown check %q, expected %s or %s", name, readyCheckInstance, readyCheckWebSocket), http.StatusBadRequest)
+			return
+		}
+		required[name] = true
+	}
+
+	checks := []readyCheck{
+		s.checkConfigLoaded(),
+		s.checkNotShuttingDown(),
+		s.checkListenersBound(),
+		s.checkInstanceRunning(required[readyCheckInstance]),
+		s.checkWangshuConnected(required[readyCheckWebSocket]),
+	}
+	status, code := "ready", http.StatusOK
+	for _, check := range checks {
+		if check.Required && !check.OK {
+			status, code = "not_ready", http.StatusServiceUnavailable
+			break
+		}
+	}
+	writeHealth(w, code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// checkConfigLoaded checks that the manager holds a config wangshu can run
+// with, which needs at least one agent.
+func (s *Server) checkConfigLoaded() readyCheck {
+	s.cfgMu.RLock()
+	loaded := s.cfg != nil && len(s.cfg.Agents) > 0
+	s.cfgMu.RUnlock()
+
+	check := readyCheck{Name: "config", OK: loaded, Required: true}
+	if !loaded {
+		check.Error = "config is not loaded or has no agents"
+	}
+	return check
+}
+
+func (s *Server) checkNotShuttingDown() readyCheck {
+	check := readyCheck{Name: "shutdown", OK: !s.shuttingDown.Load(), Required: true}
+	if !check.OK {
+		check.Error = "manager is shutting down"
+	}
+	return check
+}
+
+func (s *Server) checkListenersBound() readyCheck {
+	check := readyCheck{Name: "listeners", OK: true, Required: true}
+	listeners := s.listeners.Status()
+	failed := 0
+	for _, l := range listeners {
+		if l.Status != ListenerRunning {
+			slog.Warn("Listener is not running", "channel", l.Channel, "address", l.Address, "status", l.Status, "error", l.Error)
+			failed++
+		}
+	}
+	if failed > 0 {
+		check.OK = false
+		check.Error = fmt.Sprintf("%d of %d listeners are not running", failed, len(listeners))
+	}
+	return check
+}
+
+func (s *Server) checkInstanceRunning(required bool) readyCheck {
+	running := s.instanceCheck.get(time.Now(), instanceCheckTTL, func() bool {
+		_, err := s.processManager.FindRunningProcess()
+		return err == nil
+	})
+	check := readyCheck{Name: readyCheckInstance, OK: running, Required: required}
+	if !running {
+		check.Error = "wangshu is not running"
+	}
+	return check
+}
+
+// cachedCheck remembers the outcome of a check that is too costly to run
+// on every request.
+type cachedCheck struct {
+	mu      sync.Mutex
+	checked time.Time
+	ok      bool
+}
+
+// get returns the last outcome if it is younger than ttl and runs check
+// otherwise.
+func (c *cachedCheck) get(now time.Time, ttl time.Duration, check func() bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.checked.IsZero() || now.Sub(c.checked) >= ttl {
+		c.ok = check()
+		c.checked = now
+	}
+	return c.ok
+}
+
+func (s *Server) checkWangshuConnected(required bool) readyCheck {
+	check := readyCheck{Name: readyCheckWebSocket, OK: true, Required: required}
+	if _, wangshu := s.countClients(); wangshu == 0 {
+		check.OK = false
+		check.Error = "wangshu is not connected"
+	}
+	return check
+}
+
+func healthMethodAllowed(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == "GET" || r.Method == "HEAD" {
+		return true
+	}
+	w.Header().Set("Allow", "GET, HEAD")
+	httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func writeHealth(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadyzHidesListenerDetails(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.listeners.listeners["lan"] = &listener{status: ListenerStatus{
+		Channel: "lan",
+		Address: "192.168.1.10:8443",
+		Status:  ListenerFailed,
+		Error:   "listen tcp 192.168.1.10:8443: bind: address already in use",
+	}}
+	ts := serveListener(t, s, defaultListenerName)
+
+	resp, err := ts.Client().Get(ts.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	for _, leak := range []string{"lan", "192.168.1.10", "address already in use"} {
+		if strings.Contains(string(data), leak) {
+			t.Errorf("/readyz response contains %q: %s", leak, data)
+		}
+	}
+
+	var body struct {
+		Checks []readyCheck `json:"checks"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		t.Fatal(err)
+	}
+	for _, check := range body.Checks {
+		if check.Name == "listeners" && check.OK {
+			t.Error("listeners check passed with a failed listener")
+		}
+	}
+}
+
+func TestReadyzConfigCheck(t *testing.T) {
+	s := newTestServer(t, nil)
+	ts := serveListener(t, s, defaultListenerName)
+	if got := apiStatus(t, ts, "GET", "/readyz", ""); got != http.StatusOK {
+		t.Errorf("GET /readyz = %d, want %d", got, http.StatusOK)
+	}
+
+	s.cfgMu.Lock()
+	s.cfg.Agents = nil
+	s.cfgMu.Unlock()
+	if got := apiStatus(t, ts, "GET", "/readyz", ""); got != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz without agents = %d, want %d", got, http.StatusServiceUnavailable)
+	}
+}
+
+func TestCachedCheck(t *testing.T) {
+	var c cachedCheck
+	runs := 0
+	check := func() bool {
+		runs++
+		return runs == 1
+	}
+
+	tests := []struct {
+		after time.Duration
+		ok    bool
+		runs  int
+	}{
+		{0, true, 1},
+		{instanceCheckTTL - time.Millisecond, true, 1},
+		{instanceCheckTTL, false, 2},
+		{instanceCheckTTL + time.Second, false, 2},
+	}
+	for _, tt := range tests {
+		if got := c.get(limiterEpoch.Add(tt.after), instanceCheckTTL, check); got != tt.ok || runs != tt.runs {
+			t.Errorf("get(+%v) = %v after %d runs, want %v after %d", tt.after, got, runs, tt.ok, tt.runs)
+		}
+	}
+}
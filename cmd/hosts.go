@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+// listenerHosts returns the configured address of the listener that
+// received r, or "" if it is not known, and its allowed_hosts.
+func (s *Server) listenerHosts(r *http.Request) (addr string, allowed []string) {
+	name := listenerChannel(r)
+	s.cfgMu.RLock()
+	channel, ok := s.webChannels[name]
+	s.cfgMu.RUnlock()
+	if ok {
+		web, _ := channel.Web()
+		return web.Address(), web.AllowedHosts
+	}
+	if name == defaultListenerName {
+		return defaultListenerAddress, nil
+	}
+	return "", nil
+}
+
+// hostAllowed reports whether hostport names the listener that received r:
+// its configured host, one of its allowed_hosts or a loopback name, on the
+// port it accepted r on. Listeners that bind every interface accept any host
+// name, since they require TLS and a token. Unix sockets are not reachable
+// from other hosts and accept any Host header.
+func (s *Server) hostAllowed(r *http.Request, hostport string) bool {
+	addr, allowed := s.listenerHosts(r)
+	if _, unix := config.UnixSocketPath(addr); unix {
+		return true
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if _, p, err := net.SplitHostPort(local.String()); err == nil {
+			port = p
+		}
+	}
+
+	reqHost, reqPort, err := net.SplitHostPort(hostport)
+	if err != nil {
+		reqHost, reqPort = strings.Trim(hostport, "[]"), "80"
+		if r.TLS != nil {
+			reqPort = "443"
+		}
+	}
+	if reqPort != port {
+		return false
+	}
+	if strings.EqualFold(reqHost, host) || config.IsLoopbackAddress(net.JoinHostPort(reqHost, port)) {
+		return true
+	}
+	for _, name := range allowed {
+		if strings.EqualFold(reqHost, name) {
+			return true
+		}
+	}
+	ip, err := netip.ParseAddr(host)
+	return host == "" || err == nil && ip.IsUnspecified()
+}
+
+// filterHosts rejects requests whose Host header does not name the listener
+// that received them, so pages of other sites cannot reach a local listener
+// by pointing their own host name at it (DNS rebinding).
+func (s *Server) filterHosts(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.hostAllowed(r, r.Host) {
+			slog.Warn("Rejected request for unknown host", "channel", listenerChannel(r), "host", r.Host, "remote", r.RemoteAddr, "path", r.URL.Path)
+			writeError(w, r, http.StatusForbidden, codeHostNotAllowed, "Forbidden: unknown host")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
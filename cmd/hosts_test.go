@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+func hostTestRequest(channel, host, origin string) *http.Request {
+	r := httptest.NewRequest("GET", "/api/v1/listeners", nil)
+	ctx := context.WithValue(r.Context(), listenerChannelKey{}, channel)
+	ctx = context.WithValue(ctx, http.LocalAddrContextKey, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 18080})
+	r = r.WithContext(ctx)
+	r.Host = host
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	return r
+}
+
+func TestHostAllowed(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.webChannels = map[string]config.ChannelConfig{
+		"local": webTestChannel("token", false),
+		"lan":   {Type: config.ChannelTypeWeb, Enabled: true, Settings: &config.WebSettings{HostAddress: "manager.lan:18080"}},
+		"any":   {Type: config.ChannelTypeWeb, Enabled: true, Settings: &config.WebSettings{HostAddress: ":18080"}},
+		"tls": {Type: config.ChannelTypeWeb, Enabled: true, Settings: &config.WebSettings{
+			HostAddress:  "192.168.1.10:18080",
+			AllowedHosts: []string{"manager.example.com"},
+		}},
+		"unix": {Type: config.ChannelTypeWeb, Enabled: true, Settings: &config.WebSettings{HostAddress: "unix:/tmp/wsm.sock"}},
+	}
+
+	tests := []struct {
+		channel string
+		host    string
+		want    bool
+	}{
+		{"local", "localhost:18080", true},
+		{"local", "127.0.0.1:18080", true},
+		{"local", "[::1]:18080", true},
+		{"local", "LOCALHOST:18080", true},
+		{"local", "evil.example:18080", false},
+		{"local", "localhost:8080", false},
+		{"local", "localhost", false},
+		{"lan", "manager.lan:18080", true},
+		{"lan", "localhost:18080", true},
+		{"lan", "other.lan:18080", false},
+		{"any", "manager.example.com:18080", true},
+		{"any", "manager.example.com:443", false},
+		{"tls", "192.168.1.10:18080", true},
+		{"tls", "manager.example.com:18080", true},
+		{"tls", "MANAGER.example.com:18080", true},
+		{"tls", "manager.example.com:8443", false},
+		{"tls", "other.example.com:18080", false},
+		{"local", "manager.example.com:18080", false},
+		{"unix", "evil.example", true},
+		{defaultListenerName, "localhost:18080", true},
+		{defaultListenerName, "rebind.evil.example:18080", false},
+		{"unknown", "localhost:18080", false},
+	}
+	for _, tt := range tests {
+		if got := s.hostAllowed(hostTestRequest(tt.channel, tt.host, ""), tt.host); got != tt.want {
+			t.Errorf("hostAllowed(%s, %q) = %v, want %v", tt.channel, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	s := newTestServer(t, map[string]config.ChannelConfig{"web": webTestChannel("token", false)})
+
+	tests := []struct {
+		host   string
+		origin string
+		want   bool
+	}{
+		{"localhost:18080", "", true},
+		{"localhost:18080", "http://localhost:18080", true},
+		{"localhost:18080", "https://localhost:18080", false},
+		{"localhost:18080", "http://127.0.0.1:18080", false},
+		{"rebind.evil.example:18080", "http://rebind.evil.example:18080", false},
+		{"localhost:18080", "null", false},
+	}
+	for _, tt := range tests {
+		if got := s.sameOrigin(hostTestRequest("web", tt.host, tt.origin)); got != tt.want {
+			t.Errorf("sameOrigin(Host %q, Origin %q) = %v, want %v", tt.host, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestListenerRejectsUnknownHost(t *testing.T) {
+	s := newTestServer(t, nil)
+	ts := serveListener(t, s, defaultListenerName)
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	for host, want := range map[string]int{
+		"localhost:" + port:           http.StatusOK,
+		"rebind.evil.example:" + port: http.StatusForbidden,
+	} {
+		req, err := http.NewRequest("GET", ts.URL+"/api/v1/listeners", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = host
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET with Host %q = %d, want %d", host, resp.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+// ipFilters returns the parsed allowed_ips of every channel that has them.
+// Channels with invalid entries are rejected by webListenerChannels and
+// never reach this point.
+func ipFilters(channels map[string]config.ChannelConfig) map[string][]netip.Prefix {
+	filters := make(map[string][]netip.Prefix)
+	for name, channel := range channels {
+		web, _ := channel.Web()
+		if len(web.AllowedIPs) == 0 {
+			continue
+		}
+		if prefixes, err := config.ParseAllowedIPs(web.AllowedIPs); err == nil {
+			filters[name] = prefixes
+		}
+	}
+	return filters
+}
+
+// filterIPs rejects requests whose client address is not in the allowed_ips
+// of the listener that received them. Listeners without allowed_ips accept
+// every client.
+func (s *Server) filterIPs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.cfgMu.RLock()
+		prefixes := s.ipFilters[listenerChannel(r)]
+		s.cfgMu.RUnlock()
+
+		if len(prefixes) > 0 && !ipAllowed(r.RemoteAddr, prefixes) {
+			slog.Warn("Rejected request from disallowed address", "channel", listenerChannel(r), "remote", r.RemoteAddr, "path", r.URL.Path)
+			writeError(w, r, http.StatusForbidden, codeForbidden, "Forbidden: address not allowed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ipAllowed(remoteAddr string, prefixes []netip.Prefix) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
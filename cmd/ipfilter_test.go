@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+func TestIPAllowed(t *testing.T) {
+	prefixes, err := config.ParseAllowedIPs([]string{"192.168.1.0/24", "10.0.0.5", "2001:db8::/32", "::ffff:172.16.0.1", "::ffff:100.64.0.0/106"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remote string
+		want   bool
+	}{
+		{"192.168.1.20:5000", true},
+		{"192.168.2.20:5000", false},
+		{"10.0.0.5:5000", true},
+		{"10.0.0.6:5000", false},
+		{"[::ffff:192.168.1.20]:5000", true},
+		{"[::ffff:10.0.0.6]:5000", false},
+		{"172.16.0.1:5000", true},
+		{"100.64.1.1:5000", true},
+		{"[::ffff:100.64.1.1]:5000", true},
+		{"[2001:db8::1]:5000", true},
+		{"[2001:db9::1]:5000", false},
+		{"192.168.1.20", false},
+		{"@:5000", false},
+	}
+	for _, tt := range tests {
+		if got := ipAllowed(tt.remote, prefixes); got != tt.want {
+			t.Errorf("ipAllowed(%q) = %v, want %v", tt.remote, got, tt.want)
+		}
+	}
+}
+
+func TestFilterIPs(t *testing.T) {
+	allowed := webTestChannel("token", false)
+	allowed.Settings.(*config.WebSettings).AllowedIPs = []string{"127.0.0.0/8"}
+	denied := webTestChannel("token", false)
+	denied.Settings.(*config.WebSettings).AllowedIPs = []string{"10.0.0.0/8"}
+	s := newTestServer(t, map[string]config.ChannelConfig{"allowed": allowed, "denied": denied})
+
+	if got := apiStatus(t, serveListener(t, s, "allowed"), "GET", "/api/v1/listeners", "token"); got != http.StatusOK {
+		t.Errorf("GET from allowed address = %d, want %d", got, http.StatusOK)
+	}
+
+	ts := serveListener(t, s, "denied")
+	resp, err := ts.Client().Get(ts.URL + "/api/v1/listeners")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body apiEnvelope
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET from disallowed address = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+	if body.Error == nil || body.Error.Code != codeForbidden {
+		t.Errorf("error = %+v, want code %s", body.Error, codeForbidden)
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+const (
+	ListenerRunning  = "running"
+	ListenerFailed   = "failed"
+	ListenerRejected = "rejected"
+
+	defaultListenerName    = "default"
+	defaultListenerAddress = "localhost:8080"
+	listenerDrainTimeout   = 10 * time.Second
+)
+
+type ListenerStatus struct {
+	Channel   string     `json:"channel"`
+	Address   string     `json:"address"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	TLS       bool       `json:"tls"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+}
+
+// listenerSpec is what a listener is started with. A listener is restarted
+// whenever its spec changes.
+type listenerSpec struct {
+	Address       string
+	TLSCert       string
+	TLSKey        string
+	TLSSelfSigned bool
+	SocketMode    os.FileMode
+}
+
+func (spec listenerSpec) tls() bool {
+	return spec.TLSSelfSigned || spec.TLSCert != ""
+}
+
+type listener struct {
+	spec   listenerSpec
+	status ListenerStatus
+	server *http.Server
+	ln     net.Listener
+}
+
+// ListenerSupervisor keeps one HTTP listener per enabled web channel and
+// reconciles them with the config whenever it changes.
+type ListenerSupervisor struct {
+	handler   http.Handler
+	certDir   string
+	mu        sync.Mutex
+	listeners map[string]*listener
+	// closed is set once Shutdown or Close was called, after which Apply
+	// starts no more listeners.
+	closed    bool
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewListenerSupervisor returns a supervisor that serves handler on every
+// listener. Self-signed certificates are kept in certDir.
+func NewListenerSupervisor(handler http.Handler, certDir string) *ListenerSupervisor {
+	return &ListenerSupervisor{
+		handler:   handler,
+		certDir:   certDir,
+		listeners: make(map[string]*listener),
+		done:      make(chan struct{}),
+	}
+}
+
+type listenerChannelKey struct{}
+
+// listenerChannel returns the name of the channel whose listener received r.
+func listenerChannel(r *http.Request) string {
+	name, _ := r.Context().Value(listenerChannelKey{}).(string)
+	return name
+}
+
+// webListenerChannels returns the enabled web channels of cfg that may be
+// served, plus a status entry for every channel that was rejected. Channels
+// without a token are rejected unless they opt out of authentication, and
+// non-loopback addresses unless the channel allows remote access safely.
+func webListenerChannels(cfg *config.Config) (map[string]config.ChannelConfig, []ListenerStatus) {
+	channels := make(map[string]config.ChannelConfig)
+	var rejected []ListenerStatus
+	for channelName, channel := range cfg.Channels {
+		web, ok := channel.Web()
+		if !ok || !channel.Enabled {
+			continue
+		}
+		addr := web.Address()
+		if err := web.CheckRemote(); err != nil {
+			slog.Warn("Skipping web channel", "channel", channelName, "address", addr, "error", err)
+			rejected = append(rejected, ListenerStatus{
+				Channel: channelName,
+				Address: addr,
+				Status:  ListenerRejected,
+				Error:   err.Error(),
+			})
+			continue
+		}
+		if err := checkListenerSettings(web); err != nil {
+			slog.Error("Skipping web channel with invalid settings", "channel", channelName, "error", err)
+			rejected = append(rejected, ListenerStatus{
+				Channel: channelName,
+				Address: addr,
+				Status:  ListenerRejected,
+				Error:   err.Error(),
+			})
+			continue
+		}
+		if web.Token == "" && !web.NoAuth && !web.PeerAuth() {
+			slog.Error("Refusing to serve web channel without a token, set a token or no_auth", "channel", channelName)
+			rejected = append(rejected, ListenerStatus{
+				Channel: channelName,
+				Address: addr,
+				Status:  ListenerRejected,
+				Error:   "token is empty and no_auth is not set",
+			})
+			continue
+		}
+		if web.NoAuth {
+			slog.Warn("Web channel serves without authentication", "channel", channelName, "address", addr)
+		}
+		if web.PeerAuth() && !peerCredSupported {
+			slog.Warn("socket_roles has no effect on this platform, clients need a token", "channel", channelName)
+		}
+		if !config.IsLoopbackAddress(addr) {
+			slog.Warn("Web channel is reachable from other hosts", "channel", channelName, "address", addr, "allowed_ips", web.AllowedIPs)
+		}
+		channels[channelName] = channel
+	}
+	return channels, rejected
+}
+
+// checkListenerSettings checks the settings a listener cannot start without.
+func checkListenerSettings(web *config.WebSettings) error {
+	if _, err := config.ParseAllowedIPs(web.AllowedIPs); err != nil {
+		return err
+	}
+	if _, unix := config.UnixSocketPath(web.Address()); !unix {
+		return nil
+	}
+	if _, err := web.SocketFileMode(); err != nil {
+		return err
+	}
+	_, err := web.SocketRoleMap()
+	return err
+}
+
+// Apply starts, stops and rebinds listeners so that there is exactly one per
+// channel in channels, or a single default listener when no web channel is
+// configured at all.
+// Listeners whose address changed are closed before new ones are bound, so a
+// port can move between channels in one step. It returns the number of
+// listeners that are running afterwards.
+func (ls *ListenerSupervisor) Apply(channels map[string]config.ChannelConfig, rejected []ListenerStatus) int {
+	desired := make(map[string]listenerSpec, len(channels))
+	for name, channel := range channels {
+		web, _ := channel.Web()
+		mode, _ := web.SocketFileMode()
+		desired[name] = listenerSpec{
+			Address:       web.Address(),
+			TLSCert:       config.ExpandPath(web.TLSCert),
+			TLSKey:        config.ExpandPath(web.TLSKey),
+			TLSSelfSigned: web.TLSSelfSigned,
+			SocketMode:    mode,
+		}
+	}
+	if len(desired) == 0 && len(rejected) == 0 {
+		desired[defaultListenerName] = listenerSpec{Address: defaultListenerAddress}
+		slog.Info("No web channels configured, using default address", "address", defaultListenerAddress)
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.closed {
+		return 0
+	}
+
+	for name, l := range ls.listeners {
+		spec, keep := desired[name]
+		if keep && spec == l.spec && l.status.Status == ListenerRunning {
+			continue
+		}
+		ls.stopLocked(name, l)
+	}
+
+	for _, name := range sortedNames(desired) {
+		if _, running := ls.listeners[name]; running {
+			continue
+		}
+		ls.startLocked(name, desired[name])
+	}
+
+	for _, status := range rejected {
+		ls.listeners[status.Channel] = &listener{status: status}
+	}
+
+	running := 0
+	for _, l := range ls.listeners {
+		if l.status.Status == ListenerRunning {
+			running++
+		}
+	}
+	return running
+}
+
+func (ls *ListenerSupervisor) startLocked(name string, spec listenerSpec) {
+	addr := spec.Address
+	l := &listener{spec: spec, status: ListenerStatus{Channel: name, Address: addr, TLS: spec.tls()}}
+	ls.listeners[name] = l
+
+	tlsConfig, err := ls.tlsConfig(name, spec)
+	if err != nil {
+		slog.Error("Failed to set up TLS for web channel listener", "channel", name, "address", addr, "error", err)
+		l.status.Status = ListenerFailed
+		l.status.Error = err.Error()
+		return
+	}
+
+	var ln net.Listener
+	if path, unix := config.UnixSocketPath(addr); unix {
+		ln, err = listenUnix(path, spec.SocketMode)
+	} else {
+		ln, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		slog.Error("Failed to bind web channel listener", "channel", name, "address", addr, "error", err)
+		l.status.Status = ListenerFailed
+		l.status.Error = err.Error()
+		return
+	}
+
+	l.ln = ln
+	l.server = &http.Server{
+		Addr:      addr,
+		Handler:   ls.handler,
+		TLSConfig: tlsConfig,
+		BaseContext: func(net.Listener) context.Context {
+			return context.WithValue(context.Background(), listenerChannelKey{}, name)
+		},
+		ConnContext: peerConnContext,
+	}
+	l.status.Status = ListenerRunning
+	now := time.Now()
+	l.status.StartedAt = &now
+	slog.Info("Starting server", "channel", name, "address", addr, "tls", spec.tls())
+
+	go func(srv *http.Server) {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ServeTLS(ln, "", "")
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+			slog.Error("Server error", "channel", name, "error", err)
+			ls.mu.Lock()
+			if current, ok := ls.listeners[name]; ok && current.server == srv {
+				current.status.Status = ListenerFailed
+				current.status.Error = err.Error()
+			}
+			ls.mu.Unlock()
+		}
+	}(l.server)
+}
+
+// tlsConfig returns the TLS config of a listener, or nil if it serves plain
+// HTTP. Self-signed certificates are generated on first use and kept per
+// channel, so browsers only need to trust them once.
+func (ls *ListenerSupervisor) tlsConfig(name string, spec listenerSpec) (*tls.Config, error) {
+	if !spec.tls() {
+		return nil, nil
+	}
+	certFile, keyFile := spec.TLSCert, spec.TLSKey
+	if spec.TLSSelfSigned {
+		base := filepath.Join(ls.certDir, url.PathEscape(name))
+		certFile, keyFile = base+".crt", base+".key"
+		if err := ensureSelfSigned(certFile, keyFile, spec.Address); err != nil {
+			return nil, err
+		}
+	}
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}, nil
+}
+
+// stopLocked closes the socket right away so the address can be reused, and
+// lets requests that are still running finish in the background.
+func (ls *ListenerSupervisor) stopLocked(name string, l *listener) {
+	delete(ls.listeners, name)
+	if l.server == nil {
+		return
+	}
+	slog.Info("Stopping server", "channel", name, "address", l.status.Address)
+	l.ln.Close()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), listenerDrainTimeout)
+		defer cancel()
+		if err := l.server.Shutdown(ctx); err != nil {
+			slog.Warn("Server did not drain in time", "channel", name, "error", err)
+			l.server.Close()
+		}
+	}()
+}
+
+func (ls *ListenerSupervisor) Status() []ListenerStatus {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	statuses := make([]ListenerStatus, 0, len(ls.listeners))
+	for _, name := range sortedNames(ls.listeners) {
+		statuses = append(statuses, ls.listeners[name].status)
+	}
+	return statuses
+}
+
+// Wait blocks until Shutdown or Close is called.
+func (ls *ListenerSupervisor) Wait() {
+	<-ls.done
+}
+
+// Shutdown stops every listener from accepting connections and waits for
+// in-flight requests to finish. Servers still busy when ctx is done are
+// closed forcibly. Wait returns once Shutdown has been called.
+//
+// The servers are drained without holding the lock, so in-flight requests
+// that read the listener status or reload the config can finish.
+func (ls *ListenerSupervisor) Shutdown(ctx context.Context) error {
+	ls.mu.Lock()
+	ls.closed = true
+	listeners := ls.listeners
+	ls.listeners = make(map[string]*listener)
+	ls.mu.Unlock()
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, 0, len(listeners))
+		mu   sync.Mutex
+	)
+	for name, l := range listeners {
+		if l.server == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				slog.Warn("Server did not drain in time", "channel", name, "error", err)
+				srv.Close()
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				mu.Unlock()
+			}
+		}(name, l.server)
+	}
+	wg.Wait()
+	ls.closeOnce.Do(func() { close(ls.done) })
+	return errors.Join(errs...)
+}
+
+func (ls *ListenerSupervisor) Close() error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.closed = true
+
+	var errs []error
+	for name, l := range ls.listeners {
+		delete(ls.listeners, name)
+		if l.server == nil {
+			continue
+		}
+		if err := l.server.Close(); err != nil {
+			slog.Error("Failed to close server", "channel", name, "error", err)
+			errs = append(errs, err)
+		}
+	}
+	ls.closeOnce.Do(func() { close(ls.done) })
+	return errors.Join(errs...)
+}
+
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+func TestShutdownLetsRequestsReadStatus(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var ls *ListenerSupervisor
+	ls = NewListenerSupervisor(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		ls.Status()
+		ls.Apply(nil, nil)
+		w.WriteHeader(http.StatusOK)
+	}), t.TempDir())
+	channels := map[string]config.ChannelConfig{
+		"web": {Type: config.ChannelTypeWeb, Enabled: true, Settings: &config.WebSettings{HostAddress: "127.0.0.1:0"}},
+	}
+	if running := ls.Apply(channels, nil); running != 1 {
+		t.Fatalf("Apply() = %d running listeners, want 1", running)
+	}
+	addr := ls.listeners["web"].ln.Addr().String()
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/")
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- ls.Shutdown(ctx) }()
+	// Let Shutdown take the listeners before the request reads them.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Shutdown() = %v, want in-flight requests drained", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Shutdown did not return while a request read the listener status")
+	}
+	if got := <-status; got != http.StatusOK {
+		t.Errorf("in-flight request = %d, want %d", got, http.StatusOK)
+	}
+	if got := len(ls.Status()); got != 0 {
+		t.Errorf("Apply after Shutdown left %d listeners", got)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yockii/wangshu-manager/internal/auth"
+)
+
+var errCrossOriginUpgrade = errors.New("cross-origin WebSocket upgrade")
+
+const (
+	sessionCookie = "wsm_session"
+	sessionTTL    = 12 * time.Hour
+	csrfHeader    = "X-CSRF-Token"
+)
+
+// sessionCookieName returns the session cookie of the listener that received
+// r. Browsers share cookies between ports of the same host, so every
+// listener uses its own cookie.
+func sessionCookieName(r *http.Request) string {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if _, port, err := net.SplitHostPort(addr.String()); err == nil {
+			return sessionCookie + "_" + port
+		}
+	}
+	return sessionCookie
+}
+
+// handleLogin exchanges a token, or a username and password, for a session
+// cookie. The response carries the CSRF token that mutating requests made
+// with the cookie must send in the X-CSRF-Token header.
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "Invalid request body")
+		return
+	}
+
+	// presented is what failures are counted by: the username, so guessing
+	// passwords for one account locks that account out for this client.
+	presented := "user:" + req.Username
+	if req.Username == "" {
+		presented = req.Token
+	}
+	if !s.limitCredential(w, r, presented) {
+		return
+	}
+
+	var (
+		p          principal
+		credential string
+		ok         bool
+	)
+	switch {
+	case req.Username != "":
+		var user auth.User
+		if user, ok = s.users.Authenticate(req.Username, req.Password); ok {
+			p = principal{Name: "user:" + user.Username, Role: user.Role, User: user.Username}
+			credential = auth.HashToken(user.PasswordHash)
+		}
+	case req.Token != "":
+		p, ok = s.authenticate(r, req.Token)
+		credential = auth.HashToken(req.Token)
+	default:
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "token or username and password are required")
+		return
+	}
+	if !ok {
+		s.authFailed(r, presented)
+		slog.Warn("Login failed", "username", req.Username, "remote", r.RemoteAddr, "request_id", requestID(r))
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+		return
+	}
+
+	sess, err := s.sessions.Create(auth.Session{
+		Name:       p.Name,
+		Role:       p.Role,
+		User:       p.User,
+		TokenID:    p.TokenID,
+		Channel:    p.Channel,
+		Credential: credential,
+	})
+	if err != nil {
+		slog.Error("Failed to create session", "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, "Failed to create session")
+		return
+	}
+	s.authSucceeded(r, presented)
+	setIdentity(r, p.Name)
+	s.audit.Record(r, p, http.StatusOK)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName(r),
+		Value:    sess.ID,
+		Path:     "/",
+		Expires:  sess.ExpiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	writeSession(w, r, p, &sess)
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookieName(r)); err == nil {
+		s.sessions.Delete(c.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName(r),
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// handleSession describes the caller, including the CSRF token of its
+// session so a reloaded page can pick it up again.
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	p, sess, _ := s.authenticateRequest(r)
+	writeSession(w, r, p, sess)
+}
+
+func writeSession(w http.ResponseWriter, r *http.Request, p principal, sess *auth.Session) {
+	resp := map[string]interface{}{
+		"name": p.Name,
+		"role": p.Role,
+	}
+	if sess != nil {
+		resp["csrf_token"] = sess.CSRFToken
+		resp["expires_at"] = sess.ExpiresAt
+	}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+// authenticateRequest authenticates r by the token it carries, or by its
+// session cookie when it carries none. The session is nil unless the cookie
+// was used.
+func (s *Server) authenticateRequest(r *http.Request) (principal, *auth.Session, bool) {
+	if token := requestToken(r); token != "" {
+		p, ok := s.authenticate(r, token)
+		return p, nil, ok
+	}
+	if c, err := r.Cookie(sessionCookieName(r)); err == nil {
+		if sess, ok := s.sessions.Get(c.Value); ok && s.sessionValid(r, sess) {
+			p := principal{Name: sess.Name, Role: sess.Role, User: sess.User, TokenID: sess.TokenID, Channel: sess.Channel}
+			return p, &sess, true
+		}
+	}
+	p, ok := s.authenticate(r, "")
+	return p, nil, ok
+}
+
+// sessionValid reports whether the credential sess was created with is still
+// accepted, so revoking a token, changing a channel token or changing a
+// user's password or role also ends the sessions created with it.
+func (s *Server) sessionValid(r *http.Request, sess auth.Session) bool {
+	if sess.User != "" {
+		u, ok := s.users.Get(sess.User)
+		return ok && u.Role == sess.Role && auth.HashToken(u.PasswordHash) == sess.Credential
+	}
+	if sess.TokenID != "" {
+		t, ok := s.tokens.Get(sess.TokenID)
+		return ok && t.Role == sess.Role
+	}
+
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+
+	if sess.Channel != "" {
+		if listenerChannel(r) != sess.Channel {
+			return false
+		}
+		channel, ok := s.webChannels[sess.Channel]
+		if !ok {
+			return false
+		}
+		web, _ := channel.Web()
+		return web.Token != "" && auth.HashToken(web.Token) == sess.Credential
+	}
+	return s.adminToken != "" && auth.HashToken(s.adminToken) == sess.Credential
+}
+
+// checkSessionRequest guards requests authenticated by a session cookie, which
+// the browser attaches on its own: WebSocket upgrades must come from an allowed
+// origin, and mutating requests must carry the session's CSRF token.
+func (s *Server) checkSessionRequest(r *http.Request, sess *auth.Session) error {
+	if websocket.IsWebSocketUpgrade(r) {
+		if !s.originAllowed(r) {
+			return errCrossOriginUpgrade
+		}
+		return nil
+	}
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return nil
+	}
+	token := r.Header.Get(csrfHeader)
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(sess.CSRFToken)) != 1 {
+		return errors.New("missing or invalid CSRF token")
+	}
+	return nil
+}
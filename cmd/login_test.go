@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yockii/wangshu-manager/internal/auth"
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+// login signs in to ts with token and returns the session cookie together
+// with the CSRF token of the session.
+func login(t *testing.T, ts *httptest.Server, token string) (*http.Cookie, string) {
+	t.Helper()
+	resp, err := ts.Client().Post(ts.URL+"/api/v1/login", "application/json", strings.NewReader(`{"token":"`+token+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Data struct {
+			CSRFToken string `json:"csrf_token"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("login = %d, %v", resp.StatusCode, err)
+	}
+	cookies := resp.Cookies()
+	if len(cookies) != 1 || !strings.HasPrefix(cookies[0].Name, sessionCookie) {
+		t.Fatalf("login set cookies %v", cookies)
+	}
+	return cookies[0], body.Data.CSRFToken
+}
+
+func TestSessionCSRF(t *testing.T) {
+	s := newTestServer(t, map[string]config.ChannelConfig{"web": webTestChannel("channel-token", false)})
+	s.adminToken = "admin-token"
+	ts := serveListener(t, s, "web")
+	cookie, csrf := login(t, ts, "admin-token")
+	if csrf == "" {
+		t.Fatal("login returned no CSRF token")
+	}
+
+	requests := []struct {
+		method, path string
+		// status is what the request answers once the CSRF check passed.
+		status int
+	}{
+		{"PUT", "/api/v1/config", http.StatusBadRequest},
+		{"POST", "/api/v1/instance?action=none", http.StatusBadRequest},
+		{"DELETE", "/api/v1/tokens/missing", http.StatusNotFound},
+	}
+	for _, req := range requests {
+		for _, tt := range []struct {
+			name   string
+			header string
+			status int
+		}{
+			{"missing", "", http.StatusForbidden},
+			{"wrong", csrf + "x", http.StatusForbidden},
+			{"valid", csrf, req.status},
+		} {
+			t.Run(req.method+" "+tt.name, func(t *testing.T) {
+				r, err := http.NewRequest(req.method, ts.URL+req.path, strings.NewReader("not json"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				r.AddCookie(cookie)
+				if tt.header != "" {
+					r.Header.Set(csrfHeader, tt.header)
+				}
+				resp, err := ts.Client().Do(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var body apiEnvelope
+				json.NewDecoder(resp.Body).Decode(&body)
+				resp.Body.Close()
+				if resp.StatusCode != tt.status {
+					t.Errorf("%s %s = %d, want %d", req.method, req.path, resp.StatusCode, tt.status)
+				}
+				if tt.status == http.StatusForbidden && (body.Error == nil || body.Error.Code != codeCSRFFailed) {
+					t.Errorf("error = %+v, want code %s", body.Error, codeCSRFFailed)
+				}
+			})
+		}
+	}
+
+	// Requests authenticated by a token carry no cookie a page could have
+	// attached, so they need no CSRF token.
+	if got := apiStatus(t, ts, "PUT", "/api/v1/config", "admin-token"); got != http.StatusBadRequest {
+		t.Errorf("PUT /api/v1/config with token = %d, want %d", got, http.StatusBadRequest)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	s := newTestServer(t, map[string]config.ChannelConfig{"web": webTestChannel("channel-token", false)})
+	s.sessions = auth.NewSessionStore(50 * time.Millisecond)
+	ts := serveListener(t, s, "web")
+	cookie, _ := login(t, ts, "channel-token")
+
+	get := func() int {
+		r, err := http.NewRequest("GET", ts.URL+"/api/v1/listeners", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.AddCookie(cookie)
+		resp, err := ts.Client().Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if got := get(); got != http.StatusOK {
+		t.Fatalf("GET with session = %d, want %d", got, http.StatusOK)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if got := get(); got != http.StatusUnauthorized {
+		t.Errorf("GET with expired session = %d, want %d", got, http.StatusUnauthorized)
+	}
+}
+
+func TestSessionWebSocketUpgrade(t *testing.T) {
+	s := newTestServer(t, map[string]config.ChannelConfig{"web": webTestChannel("channel-token", false)})
+	ts := serveListener(t, s, "web")
+	cookie, _ := login(t, ts, "channel-token")
+
+	tests := []struct {
+		name   string
+		origin string
+		cookie bool
+		status int
+	}{
+		{"same origin", ts.URL, true, http.StatusSwitchingProtocols},
+		{"cross origin", "http://evil.example", true, http.StatusForbidden},
+		{"without cookie", ts.URL, false, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{"Origin": {tt.origin}}
+			if tt.cookie {
+				header.Set("Cookie", cookie.Name+"="+cookie.Value)
+			}
+			dialer := websocket.Dialer{HandshakeTimeout: 5 * time.Second}
+			conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/webWs", header)
+			if conn != nil {
+				conn.Close()
+			}
+			if resp == nil {
+				t.Fatalf("dial /webWs: %v", err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("dial /webWs = %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
@@ -2,22 +2,55 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/yockii/wangshu-manager/internal/auth"
 	"github.com/yockii/wangshu-manager/internal/config"
 	"github.com/yockii/wangshu-manager/internal/process"
+	"github.com/yockii/wangshu-manager/internal/provider"
+)
+
+const (
+	maxBundleBodySize = 32 << 20
+
+	// adminTokenEnv names the environment variable holding a token that is
+	// accepted on every listener.
+	adminTokenEnv = "WANGSHU_MANAGER_ADMIN_TOKEN"
+	// backendTokenEnv names the environment variable holding the token
+	// wangshu connects to /ws with. wangshu instances the manager starts
+	// inherit it.
+	backendTokenEnv = "WANGSHU_MANAGER_BACKEND_TOKEN"
+
+	wangshuOnExitStop = "stop"
+	wangshuOnExitKeep = "keep"
+
+	closeFrameTimeout = time.Second
+
+	// Prefixes of the keys of Server.clients.
+	wangshuClientPrefix = "wangshu-"
+	webClientPrefix     = "web-"
 )
 
 type Server struct {
-	servers        map[string]*http.Server
-	serversMu      sync.RWMutex
+	listeners      *ListenerSupervisor
 	upgrader       websocket.Upgrader
 	clients        map[string]*websocket.Conn
 	clientsMu      sync.RWMutex
@@ -26,114 +59,202 @@ type Server struct {
 	cfgMu          sync.RWMutex
 	processManager *process.ProcessManager
 	webChannels    map[string]config.ChannelConfig
+	ipFilters      map[string][]netip.Prefix
+	socketRoles    map[string]map[int]auth.Role
+	adminToken     string
+	backendToken   string
+	tokens         *auth.TokenStore
+	sessions       *auth.SessionStore
+	users          *auth.UserStore
+	audit          *auditLog
+	prober         *provider.Prober
+	catalog        *provider.Catalog
+	static         *staticFiles
+	metrics        *metrics
+	limiter        *clientLimiter
+	metricsToken   string
+	shuttingDown   atomic.Bool
+
+	// channelBackendOnce warns once that wangshu connected without the
+	// backend token.
+	channelBackendOnce sync.Once
+	// instanceCheck caches whether wangshu is running for /readyz.
+	instanceCheck cachedCheck
 }
 
-func NewServer(cfg *config.Config, wangshuPath string) (*Server, error) {
+// NewServer creates the server for the config at wangshuPath. Files in
+// staticDir, if set, are served in place of the embedded web frontend.
+func NewServer(cfg *config.Config, wangshuPath, staticDir string) (*Server, error) {
+	tokens, err := auth.NewTokenStore(tokenStorePath(wangshuPath))
+	if err != nil {
+		return nil, err
+	}
+	users, err := auth.NewUserStore(userStorePath(wangshuPath))
+	if err != nil {
+		return nil, err
+	}
+	static, err := newStaticFiles(staticDir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid static directory: %w", err)
+	}
+
 	s := &Server{
-		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true
-			},
-		},
 		clients:        make(map[string]*websocket.Conn),
-		servers:        make(map[string]*http.Server),
 		wangshuPath:    wangshuPath,
 		cfg:            cfg,
 		processManager: process.NewProcessManager(wangshuPath),
 		webChannels:    make(map[string]config.ChannelConfig),
+		adminToken:     os.Getenv(adminTokenEnv),
+		tokens:         tokens,
+		sessions:       auth.NewSessionStore(sessionTTL),
+		users:          users,
+		audit:          &auditLog{path: auditLogPath(wangshuPath)},
+		prober:         provider.NewProber(provider.DefaultTimeout),
+		static:         static,
+		metrics:        newMetrics(),
+		limiter:        newClientLimiter(),
+		metricsToken:   os.Getenv(metricsTokenEnv),
+		backendToken:   os.Getenv(backendTokenEnv),
 	}
+	s.upgrader.CheckOrigin = s.originAllowed
+	s.catalog = provider.NewCatalog(s.prober, provider.DefaultCatalogTTL)
+	s.catalog.Warm(cfg)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ws", s.handleWangshuWebSocket)
 	mux.HandleFunc("/webWs", s.handleWebWebSocket)
 	mux.HandleFunc("/api/", s.handleAPI)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
 	mux.HandleFunc("/", s.handleStatic)
 
-	for channelName, channel := range cfg.Channels {
-		if channel.Type == "web" && channel.Enabled {
-			addr := channel.HostAddress
-			if addr == "" {
-				addr = ":8080"
-			}
-			if !strings.HasPrefix(addr, "127.0.0.1:") && !strings.HasPrefix(addr, "localhost:") && !strings.HasPrefix(addr, ":") {
-				slog.Warn("Skipping non-local web channel address", "channel", channelName, "address", addr)
-				continue
-			}
-			s.webChannels[channelName] = channel
-			s.servers[channelName] = &http.Server{
-				Addr:    addr,
-				Handler: mux,
-			}
-			slog.Info("Configured web channel listener", "channel", channelName, "address", addr)
-		}
-	}
-
-	if len(s.servers) == 0 {
-		defaultAddr := ":8080"
-		s.servers["default"] = &http.Server{
-			Addr:    defaultAddr,
-			Handler: mux,
-		}
-		slog.Info("No web channels configured, using default address", "address", defaultAddr)
-	}
+	s.listeners = NewListenerSupervisor(s.observeRequests(mux, s.filterIPs(s.filterHosts(mux))), managerDataPath(wangshuPath, "tls"))
 
 	return s, nil
 }
 
+// Start binds the listeners of all enabled web channels and blocks until
+// Shutdown is called. It fails if no listener could be bound.
 func (s *Server) Start() error {
-	errChan := make(chan error, len(s.servers))
-	var wg sync.WaitGroup
-
-	for name, server := range s.servers {
-		wg.Add(1)
-		go func(n string, srv *http.Server) {
-			defer wg.Done()
-			slog.Info("Starting server", "channel", n, "address", srv.Addr)
-			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				slog.Error("Server error", "channel", n, "error", err)
-				errChan <- err
-			}
-		}(name, server)
+	if s.reloadListeners() == 0 {
+		return fmt.Errorf("no web channel listener could be started")
 	}
+	s.listeners.Wait()
+	return nil
+}
 
-	go func() {
-		wg.Wait()
-		close(errChan)
-	}()
-
-	if err := <-errChan; err != nil {
-		return err
+// reloadListeners brings the listeners and the accepted tokens in line with
+// the current config and returns the number of running listeners.
+func (s *Server) reloadListeners() int {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+
+	channels, rejected := webListenerChannels(s.cfg)
+	s.webChannels = channels
+	s.ipFilters = ipFilters(channels)
+	s.socketRoles = socketRoles(channels)
+	if len(channels) == 0 && len(rejected) == 0 && s.adminToken == "" {
+		slog.Warn("Default listener serves without authentication, set " + adminTokenEnv + " to protect it")
 	}
-	return nil
+	return s.listeners.Apply(channels, rejected)
 }
 
-func (s *Server) Stop() error {
+// Shutdown stops accepting connections, sends a close frame to every
+// WebSocket client and waits for in-flight requests to finish. Whatever is
+// still running when ctx is done is closed forcibly.
+func (s *Server) Shutdown(ctx context.Context) error {
 	slog.Info("wangshu Manager stopping")
-	s.clientsMu.Lock()
-	for _, conn := range s.clients {
-		conn.Close()
+	s.shuttingDown.Store(true)
+
+	drained := make(chan error, 1)
+	go func() { drained <- s.listeners.Shutdown(ctx) }()
+	s.closeClients(ctx)
+	return <-drained
+}
+
+// closeClients sends a going-away close frame to every WebSocket client and
+// waits for them to answer, which ends their read loops.
+func (s *Server) closeClients(ctx context.Context) {
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	deadline := time.Now().Add(closeFrameTimeout)
+	s.clientsMu.RLock()
+	for clientID, conn := range s.clients {
+		if err := conn.WriteControl(websocket.CloseMessage, msg, deadline); err != nil {
+			slog.Warn("Failed to send close frame", "client", clientID, "error", err)
+		}
 	}
-	s.clientsMu.Unlock()
+	s.clientsMu.RUnlock()
 
-	s.serversMu.Lock()
-	defer s.serversMu.Unlock()
-	var errs []error
-	for name, server := range s.servers {
-		if err := server.Close(); err != nil {
-			slog.Error("Failed to close server", "channel", name, "error", err)
-			errs = append(errs, err)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.clientsMu.RLock()
+		remaining := len(s.clients)
+		s.clientsMu.RUnlock()
+		if remaining == 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			s.clientsMu.Lock()
+			for clientID, conn := range s.clients {
+				slog.Warn("WebSocket client did not close in time", "client", clientID)
+				conn.Close()
+			}
+			s.clientsMu.Unlock()
+			return
+		case <-ticker.C:
 		}
 	}
-	if len(errs) > 0 {
-		return errs[0]
+}
+
+// requireBackend checks that r comes from wangshu, which authenticates with
+// the backend token, or from an admin. Operators chat over /webWs; letting
+// them connect to /ws would let them pose as wangshu and receive the
+// messages of every web client.
+//
+// Without a backend token, wangshu connects with the token of the listener's
+// web channel as it always did, so upgrading does not cut it off.
+func (s *Server) requireBackend(w http.ResponseWriter, r *http.Request) bool {
+	token := requestToken(r)
+	if s.backendToken != "" && auth.SecretEqual(token, s.backendToken) {
+		s.authSucceeded(r, token)
+		setIdentity(r, "backend")
+		return true
 	}
-	return nil
+	if s.backendToken == "" && s.isChannelToken(r, token) {
+		s.channelBackendOnce.Do(func() {
+			slog.Warn("wangshu connected to /ws with a web channel token, set " + backendTokenEnv + " so web clients cannot connect as wangshu")
+		})
+		s.authSucceeded(r, token)
+		setIdentity(r, "backend")
+		return true
+	}
+	_, ok := s.requireRole(w, r, auth.RoleAdmin)
+	return ok
+}
+
+// isChannelToken reports whether token is the token of the web channel whose
+// listener received r.
+func (s *Server) isChannelToken(r *http.Request, token string) bool {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	channel, ok := s.webChannels[listenerChannel(r)]
+	if !ok {
+		return false
+	}
+	web, _ := channel.Web()
+	return web.Token != "" && auth.SecretEqual(token, web.Token)
 }
 
 func (s *Server) handleWangshuWebSocket(w http.ResponseWriter, r *http.Request) {
-	token := r.URL.Query().Get("token")
-	if !s.validateToken(token) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if !s.limitClient(w, r) || !s.requireBackend(w, r) {
+		return
+	}
+	if s.shuttingDown.Load() {
+		writeError(w, r, http.StatusServiceUnavailable, codeUnavailable, "Server is shutting down")
 		return
 	}
 
@@ -143,7 +264,7 @@ func (s *Server) handleWangshuWebSocket(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	clientID := "wangshu-" + r.RemoteAddr
+	clientID := wangshuClientPrefix + r.RemoteAddr
 	s.clientsMu.Lock()
 	s.clients[clientID] = conn
 	s.clientsMu.Unlock()
@@ -157,7 +278,9 @@ func (s *Server) handleWangshuWebSocket(w http.ResponseWriter, r *http.Request)
 		s.clientsMu.Unlock()
 		conn.Close()
 		slog.Info("wangshu disconnected", "client", clientID)
-		s.broadcastWangshuStatus("disconnected")
+		if !s.shuttingDown.Load() {
+			s.broadcastWangshuStatus("disconnected")
+		}
 	}()
 
 	for {
@@ -171,14 +294,19 @@ func (s *Server) handleWangshuWebSocket(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		s.broadcastToClients(msg)
+		s.metrics.observeMessage(directionToWeb, s.broadcastToClients(msg) > 0)
 	}
 }
 
 func (s *Server) handleWebWebSocket(w http.ResponseWriter, r *http.Request) {
-	token := r.URL.Query().Get("token")
-	if !s.validateToken(token) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if !s.limitClient(w, r) {
+		return
+	}
+	if _, ok := s.requireRole(w, r, auth.RoleOperator); !ok {
+		return
+	}
+	if s.shuttingDown.Load() {
+		writeError(w, r, http.StatusServiceUnavailable, codeUnavailable, "Server is shutting down")
 		return
 	}
 
@@ -188,7 +316,7 @@ func (s *Server) handleWebWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	webClientID := "web-" + r.RemoteAddr
+	webClientID := webClientPrefix + r.RemoteAddr
 	s.clientsMu.Lock()
 	s.clients[webClientID] = conn
 	s.clientsMu.Unlock()
@@ -197,6 +325,7 @@ func (s *Server) handleWebWebSocket(w http.ResponseWriter, r *http.Request) {
 		s.clientsMu.Lock()
 		delete(s.clients, webClientID)
 		s.clientsMu.Unlock()
+		conn.Close()
 		slog.Info("Web client disconnected", "client", webClientID)
 	}()
 
@@ -205,7 +334,7 @@ func (s *Server) handleWebWebSocket(w http.ResponseWriter, r *http.Request) {
 	s.clientsMu.RLock()
 	wangshuConnected := false
 	for clientID := range s.clients {
-		if len(clientID) > 7 && clientID[:7] == "wangshu-" {
+		if strings.HasPrefix(clientID, wangshuClientPrefix) {
 			wangshuConnected = true
 			break
 		}
@@ -243,7 +372,7 @@ func (s *Server) handleWebWebSocket(w http.ResponseWriter, r *http.Request) {
 		wangshuConnected := false
 		wangshuCount := 0
 		for clientID, wangshuConn := range s.clients {
-			if len(clientID) > 7 && clientID[:7] == "wangshu-" {
+			if strings.HasPrefix(clientID, wangshuClientPrefix) {
 				wangshuCount++
 				if err := wangshuConn.WriteJSON(msg); err != nil {
 					slog.Error("Failed to forward message to wangshu", "error", err)
@@ -265,7 +394,7 @@ func (s *Server) handleWebWebSocket(w http.ResponseWriter, r *http.Request) {
 		}
 
 		for otherClientID, webConn := range s.clients {
-			if len(otherClientID) > 4 && otherClientID[:4] == "web-" {
+			if strings.HasPrefix(otherClientID, webClientPrefix) {
 				webClientCount++
 				if otherClientID != webClientID {
 					if err := webConn.WriteJSON(broadcastMsg); err != nil {
@@ -280,6 +409,7 @@ func (s *Server) handleWebWebSocket(w http.ResponseWriter, r *http.Request) {
 		s.clientsMu.RUnlock()
 
 		slog.Info("Message forwarding summary", "wangshu_count", wangshuCount, "web_client_count", webClientCount, "broadcast_count", broadcastCount)
+		s.metrics.observeMessage(directionToWangshu, wangshuConnected)
 
 		if !wangshuConnected {
 			slog.Warn("wangshu not connected, message not forwarded")
@@ -290,13 +420,15 @@ func (s *Server) handleWebWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Server) broadcastToClients(msg interface{}) {
+// broadcastToClients sends msg to every web client and returns the number
+// of clients that received it.
+func (s *Server) broadcastToClients(msg interface{}) int {
 	s.clientsMu.RLock()
 	defer s.clientsMu.RUnlock()
 
 	webClientCount := 0
 	for clientID, conn := range s.clients {
-		if len(clientID) > 4 && clientID[:4] == "web-" {
+		if strings.HasPrefix(clientID, webClientPrefix) {
 			if err := conn.WriteJSON(msg); err != nil {
 				slog.Error("Failed to broadcast message", "error", err)
 			} else {
@@ -306,6 +438,7 @@ func (s *Server) broadcastToClients(msg interface{}) {
 		}
 	}
 	slog.Info("Broadcasted message to web clients", "count", webClientCount)
+	return webClientCount
 }
 
 func (s *Server) broadcastWangshuStatus(status string) {
@@ -319,7 +452,7 @@ func (s *Server) broadcastWangshuStatus(status string) {
 
 	webClientCount := 0
 	for clientID, conn := range s.clients {
-		if len(clientID) > 4 && clientID[:4] == "web-" {
+		if strings.HasPrefix(clientID, webClientPrefix) {
 			if err := conn.WriteJSON(msg); err != nil {
 				slog.Error("Failed to broadcast wangshu status", "error", err)
 			} else {
@@ -332,61 +465,157 @@ func (s *Server) broadcastWangshuStatus(status string) {
 }
 
 func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
-	if !s.authenticate(r) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	path := r.URL.Path[len("/api/"):]
-	switch path {
-	case "sessions":
-		s.handleSessions(w, r)
-	case "tasks":
-		s.handleTasks(w, r)
-	case "cron":
-		s.handleCron(w, r)
-	case "config":
-		s.handleConfig(w, r)
-	case "instance":
-		s.handleInstance(w, r)
-	default:
-		http.Error(w, "Not found", http.StatusNotFound)
+	r, path := withAPIVersion(w, r, r.URL.Path[len("/api/"):])
+	if !s.handleCORS(w, r) || !s.limitClient(w, r) {
+		return
+	}
+
+	var route *apiRoute
+	for i := range apiRoutes {
+		if apiRoutes[i].match(r, path) {
+			route = &apiRoutes[i]
+			break
+		}
+	}
+	if route == nil {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Not found")
+		return
+	}
+	if isAPIV1(r) {
+		setRoute(r, "/api/"+apiV1Prefix+route.pattern)
+	} else {
+		setRoute(r, "/api/"+route.pattern)
+	}
+	op, ok := route.operation(r.Method)
+	if !ok {
+		w.Header().Set("Allow", route.allow())
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
 	}
+	if op.role == "" {
+		op.handler(s, w, r)
+		return
+	}
+
+	p, ok := s.requireRole(w, r, op.role)
+	if !ok {
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() { s.audit.Record(r, p, rec.status) }()
+		w = rec
+	}
+	op.handler(s, w, r)
 }
 
 func (s *Server) handleStatic(w http.ResponseWriter, r *http.Request) {
-	fs := http.FileServer(http.Dir("static"))
-	fs.ServeHTTP(w, r)
+	s.static.ServeHTTP(w, r)
+}
+
+// principal is the caller a request was authenticated as.
+type principal struct {
+	Name string
+	Role auth.Role
+	// User is set for local accounts, TokenID for API tokens, Channel for
+	// credentials that are only valid on the listener of that channel.
+	User    string
+	TokenID string
+	Channel string
 }
 
-func (s *Server) authenticate(r *http.Request) bool {
+// requestToken returns the token r authenticates with, if any: the
+// Authorization header, with or without the Bearer scheme, or the token
+// query parameter.
+func requestToken(r *http.Request) string {
 	token := r.Header.Get("Authorization")
 	if token == "" {
-		token = r.URL.Query().Get("token")
+		return r.URL.Query().Get("token")
+	}
+	if scheme, rest, ok := strings.Cut(token, " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(rest)
 	}
-	return s.validateToken(token)
+	return token
 }
 
-func (s *Server) validateToken(token string) bool {
+// requireRole authenticates r and checks that the caller holds at least
+// role. It writes the error response and returns false otherwise.
+func (s *Server) requireRole(w http.ResponseWriter, r *http.Request, role auth.Role) (principal, bool) {
+	p, sess, ok := s.authenticateRequest(r)
+	token := requestToken(r)
+	if !ok {
+		if token != "" {
+			s.authFailed(r, token)
+		}
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+		return principal{}, false
+	}
+	s.authSucceeded(r, token)
+	if sess != nil {
+		if err := s.checkSessionRequest(r, sess); err != nil {
+			code := codeCSRFFailed
+			if errors.Is(err, errCrossOriginUpgrade) {
+				code = codeOriginNotAllowed
+			}
+			writeError(w, r, http.StatusForbidden, code, "Forbidden: "+err.Error())
+			return principal{}, false
+		}
+	}
+	setIdentity(r, p.Name)
+	if !p.Role.Allows(role) {
+		writeError(w, r, http.StatusForbidden, codeForbidden, fmt.Sprintf("Forbidden: %s role required", role))
+		return principal{}, false
+	}
+	return p, true
+}
+
+// authenticate resolves token to a caller. The admin token and API tokens
+// are accepted on every listener; a channel token only on the listener of
+// its own channel, where it grants the operator role, as does a channel that
+// sets no_auth. The default listener, which only runs when no web channel is
+// configured, is open to admins unless an admin token is set.
+func (s *Server) authenticate(r *http.Request, token string) (principal, bool) {
+	if s.adminToken != "" && auth.SecretEqual(token, s.adminToken) {
+		return principal{Name: "admin", Role: auth.RoleAdmin}, true
+	}
+	if t, ok := s.tokens.Authenticate(token); ok {
+		return principal{Name: "token:" + t.Name, Role: t.Role, TokenID: t.ID}, true
+	}
+
 	s.cfgMu.RLock()
 	defer s.cfgMu.RUnlock()
 
-	if len(s.webChannels) == 0 {
-		return true
+	name := listenerChannel(r)
+	channel, ok := s.webChannels[name]
+	if !ok {
+		if name == defaultListenerName && s.adminToken == "" {
+			return principal{Name: "anonymous", Role: auth.RoleAdmin}, true
+		}
+		return principal{}, false
 	}
-
-	for _, channel := range s.webChannels {
-		if channel.Token == "" || token == channel.Token {
-			return true
+	web, _ := channel.Web()
+	if uid, ok := requestPeerUID(r); ok {
+		if role, ok := s.socketRoles[name][uid]; ok {
+			return principal{Name: fmt.Sprintf("uid:%d", uid), Role: role, Channel: name}, true
 		}
 	}
-	return false
+	if web.NoAuth {
+		return principal{Name: "anonymous", Role: auth.RoleOperator, Channel: name}, true
+	}
+	if web.Token != "" && auth.SecretEqual(token, web.Token) {
+		return principal{Name: "channel:" + name, Role: auth.RoleOperator, Channel: name}, true
+	}
+	return principal{}, false
 }
 
 func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
 	agentKey := r.URL.Query().Get("agent")
 	if agentKey == "" {
-		http.Error(w, "Agent parameter is required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "Agent parameter is required")
 		return
 	}
 
@@ -395,7 +624,7 @@ func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
 	s.cfgMu.RUnlock()
 
 	if !exists {
-		http.Error(w, "Agent not found", http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Agent not found")
 		return
 	}
 
@@ -405,12 +634,11 @@ func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
 	sessions, err := s.loadSessions(sessionsDir)
 	if err != nil {
 		slog.Error("Failed to load sessions", "error", err)
-		http.Error(w, "Failed to load sessions", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, "Failed to load sessions")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
 		"sessions": sessions,
 	})
 }
@@ -512,7 +740,7 @@ func (s *Server) loadMessages(filePath string) ([]Message, error) {
 func (s *Server) handleTasks(w http.ResponseWriter, r *http.Request) {
 	agentKey := r.URL.Query().Get("agent")
 	if agentKey == "" {
-		http.Error(w, "Agent parameter is required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "Agent parameter is required")
 		return
 	}
 
@@ -521,7 +749,7 @@ func (s *Server) handleTasks(w http.ResponseWriter, r *http.Request) {
 	s.cfgMu.RUnlock()
 
 	if !exists {
-		http.Error(w, "Agent not found", http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Agent not found")
 		return
 	}
 
@@ -531,12 +759,11 @@ func (s *Server) handleTasks(w http.ResponseWriter, r *http.Request) {
 	tasks, err := s.loadTasks(tasksDir)
 	if err != nil {
 		slog.Error("Failed to load tasks", "error", err)
-		http.Error(w, "Failed to load tasks", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, "Failed to load tasks")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
 		"tasks": tasks,
 	})
 }
@@ -635,7 +862,7 @@ func (s *Server) loadTaskHistory(taskPath string) ([]Message, error) {
 func (s *Server) handleCron(w http.ResponseWriter, r *http.Request) {
 	agentKey := r.URL.Query().Get("agent")
 	if agentKey == "" {
-		http.Error(w, "Agent parameter is required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "Agent parameter is required")
 		return
 	}
 
@@ -644,7 +871,7 @@ func (s *Server) handleCron(w http.ResponseWriter, r *http.Request) {
 	s.cfgMu.RUnlock()
 
 	if !exists {
-		http.Error(w, "Agent not found", http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Agent not found")
 		return
 	}
 
@@ -654,12 +881,11 @@ func (s *Server) handleCron(w http.ResponseWriter, r *http.Request) {
 	cronJobs, err := s.loadCronJobs(cronDir)
 	if err != nil {
 		slog.Error("Failed to load cron jobs", "error", err)
-		http.Error(w, "Failed to load cron jobs", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, "Failed to load cron jobs")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
 		"cronJobs": cronJobs,
 	})
 }
@@ -723,58 +949,195 @@ func (s *Server) loadCronJob(filePath string) (CronJob, error) {
 	return cronJob, nil
 }
 
-func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case "GET":
-		s.cfgMu.RLock()
-		defer s.cfgMu.RUnlock()
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"config": s.cfg,
+func (s *Server) getConfig(w http.ResponseWriter, r *http.Request) {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"config": s.cfg,
+	})
+}
+
+func (s *Server) putConfig(w http.ResponseWriter, r *http.Request) {
+	var newConfig config.Config
+	body, ok := readConfigBody(w, r, &newConfig)
+	if !ok {
+		return
+	}
+
+	validation := config.ValidateDocument(body, &newConfig, s.catalog)
+	if !validation.Valid {
+		writeErrorDetails(w, r, http.StatusUnprocessableEntity, codeValidationFailed, "Config is invalid", map[string]interface{}{
+			"success":    false,
+			"validation": validation,
 		})
-	case "PUT":
-		var newConfig config.Config
-		if err := json.NewDecoder(r.Body).Decode(&newConfig); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
-		}
+		return
+	}
+
+	s.cfgMu.Lock()
+	s.cfg = &newConfig
+	s.cfgMu.Unlock()
+
+	if err := config.SaveConfig(s.wangshuPath, &newConfig); err != nil {
+		slog.Error("Failed to save config", "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, "Failed to save config")
+		return
+	}
+	s.catalog.Warm(&newConfig)
+	s.reloadListeners()
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"validation": validation,
+	})
+}
+
+func (s *Server) handleConfigDiff(w http.ResponseWriter, r *http.Request) {
+	var proposed config.Config
+	body, ok := readConfigBody(w, r, &proposed)
+	if !ok {
+		return
+	}
+
+	s.cfgMu.RLock()
+	diff := config.Diff(s.cfg, &proposed)
+	s.cfgMu.RUnlock()
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"diff":       diff,
+		"validation": config.ValidateDocument(body, &proposed, s.catalog),
+	})
+}
+
+// readConfigBody decodes the config in the body of r into cfg and returns
+// the body, which validation checks as sent.
+func readConfigBody(w http.ResponseWriter, r *http.Request, cfg *config.Config) ([]byte, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, cfg)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "Invalid request body")
+		return nil, false
+	}
+	return body, true
+}
+
+// handleConfigSchema writes the schema document on its own, without the
+// envelope, so editors and validators can load it from its URL.
+func (s *Server) handleConfigSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	json.NewEncoder(w).Encode(config.ConfigSchema())
+}
+
+func (s *Server) handleChannelTypes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"channelTypes": config.DescribeChannelTypes(),
+	})
+}
+
+func (s *Server) handleConfigExport(w http.ResponseWriter, r *http.Request) {
+	withSkills := r.URL.Query().Get("skills") == "true"
+	passphrase := r.Header.Get("X-Bundle-Passphrase")
+
+	s.cfgMu.RLock()
+	bundle, err := config.ExportBundle(s.cfg, passphrase, withSkills)
+	s.cfgMu.RUnlock()
+	if err != nil {
+		slog.Error("Failed to export config", "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, "Failed to export config")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="wangshu-config-bundle.json"`)
+	json.NewEncoder(w).Encode(bundle)
+}
+
+func (s *Server) handleConfigImport(w http.ResponseWriter, r *http.Request) {
+	var bundle config.Bundle
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBundleBodySize)).Decode(&bundle); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "Invalid request body")
+		return
+	}
+
+	query := r.URL.Query()
+	opts := config.ImportOptions{
+		Mode:       query.Get("mode"),
+		OnConflict: query.Get("on_conflict"),
+		Passphrase: r.Header.Get("X-Bundle-Passphrase"),
+		Catalog:    s.catalog,
+	}
+	preview := query.Get("preview") == "true"
 
-		s.cfgMu.Lock()
-		s.cfg = &newConfig
+	s.cfgMu.Lock()
+	newConfig, report, err := config.ImportBundle(s.cfg, &bundle, opts)
+	if err != nil {
 		s.cfgMu.Unlock()
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
 
-		if err := config.SaveConfig(s.wangshuPath, &newConfig); err != nil {
-			slog.Error("Failed to save config", "error", err)
-			http.Error(w, "Failed to save config", http.StatusInternalServerError)
-			return
+	applied := false
+	var saveErr, skillsErr error
+	if !preview && report.Validation.Valid {
+		// Skills are written first and put back if the config cannot be
+		// saved, so the import is applied either as a whole or not at all.
+		var undoSkills func()
+		undoSkills, skillsErr = config.WriteBundleSkills(newConfig, &bundle, opts.OnConflict == config.ConflictOverwrite)
+		if skillsErr == nil {
+			if saveErr = config.SaveConfig(s.wangshuPath, newConfig); saveErr == nil {
+				s.cfg = newConfig
+				applied = true
+			} else {
+				undoSkills()
+			}
 		}
+	}
+	s.cfgMu.Unlock()
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": true,
-		})
-	default:
+	if skillsErr != nil {
+		slog.Error("Failed to write imported skills", "error", skillsErr)
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, "Failed to write skills")
+		return
 	}
+	if saveErr != nil {
+		slog.Error("Failed to save imported config", "error", saveErr)
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, "Failed to save config")
+		return
+	}
+	if applied {
+		s.catalog.Warm(newConfig)
+		s.reloadListeners()
+		slog.Info("Config imported", "mode", report.Mode, "conflicts", len(report.Conflicts))
+	}
+
+	result := map[string]interface{}{
+		"applied": applied,
+		"report":  report,
+	}
+	if !preview && !report.Validation.Valid {
+		writeErrorDetails(w, r, http.StatusUnprocessableEntity, codeValidationFailed, "Imported config is invalid", result)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, result)
 }
 
-func (s *Server) handleInstance(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case "GET":
-		s.getInstanceStatus(w, r)
-	case "POST":
-		action := r.URL.Query().Get("action")
-		switch action {
-		case "start":
-			s.startInstance(w, r)
-		case "stop":
-			s.stopInstance(w, r)
-		case "restart":
-			s.restartInstance(w, r)
-		default:
-			http.Error(w, "Invalid action", http.StatusBadRequest)
-		}
+func (s *Server) handleListeners(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"listeners": s.listeners.Status(),
+	})
+}
+
+func (s *Server) handleInstanceAction(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Query().Get("action") {
+	case "start":
+		s.startInstance(w, r)
+	case "stop":
+		s.stopInstance(w, r)
+	case "restart":
+		s.restartInstance(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "Invalid action")
 	}
 }
 
@@ -782,12 +1145,11 @@ func (s *Server) getInstanceStatus(w http.ResponseWriter, r *http.Request) {
 	status, err := s.processManager.GetStatus()
 	if err != nil {
 		slog.Error("Failed to get instance status", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
 		"status": status,
 	})
 }
@@ -795,12 +1157,11 @@ func (s *Server) getInstanceStatus(w http.ResponseWriter, r *http.Request) {
 func (s *Server) startInstance(w http.ResponseWriter, r *http.Request) {
 	if err := s.processManager.Start(false); err != nil {
 		slog.Error("Failed to start instance", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
 		"success": true,
 		"message": "Instance started successfully",
 	})
@@ -809,12 +1170,11 @@ func (s *Server) startInstance(w http.ResponseWriter, r *http.Request) {
 func (s *Server) stopInstance(w http.ResponseWriter, r *http.Request) {
 	if err := s.processManager.Stop(); err != nil {
 		slog.Error("Failed to stop instance", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
 		"success": true,
 		"message": "Instance stopped successfully",
 	})
@@ -823,41 +1183,121 @@ func (s *Server) stopInstance(w http.ResponseWriter, r *http.Request) {
 func (s *Server) restartInstance(w http.ResponseWriter, r *http.Request) {
 	if err := s.processManager.Restart(); err != nil {
 		slog.Error("Failed to restart instance", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
 		"success": true,
 		"message": "Instance restarted successfully",
 	})
 }
 
 func main() {
-	wangshuPath := "~/.wangshu/config.json"
 	if len(os.Args) > 1 {
-		wangshuPath = os.Args[1]
+		switch os.Args[1] {
+		case "convert":
+			if err := runConvert(os.Args[2:]); err != nil {
+				slog.Error("Failed to convert config", "error", err)
+				os.Exit(1)
+			}
+			return
+		case "user":
+			if err := runUser(os.Args[2:]); err != nil {
+				slog.Error("User command failed", "error", err)
+				os.Exit(1)
+			}
+			return
+		case "token":
+			if err := runToken(os.Args[2:]); err != nil {
+				slog.Error("Token command failed", "error", err)
+				os.Exit(1)
+			}
+			return
+		case "init":
+			if err := runInit(os.Args[2:]); err != nil {
+				slog.Error("Failed to initialize config", "error", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	initIfMissing := flag.Bool("init", false, "write a starter config when the config file does not exist")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for requests and wangshu to finish on shutdown")
+	onExit := flag.String("wangshu-on-exit", wangshuOnExitStop, "what to do with wangshu when the manager exits: stop or keep")
+	staticDir := flag.String("static-dir", "", "directory whose files are served in place of the built-in web frontend")
+	flag.Parse()
+
+	if *onExit != wangshuOnExitStop && *onExit != wangshuOnExitKeep {
+		slog.Error("Invalid -wangshu-on-exit, expected stop or keep", "value", *onExit)
+		os.Exit(2)
+	}
+
+	wangshuPath := defaultConfigPath
+	if flag.NArg() > 0 {
+		wangshuPath = flag.Arg(0)
+	}
+
+	if *initIfMissing && !config.Exists(wangshuPath) {
+		if err := initConfig(wangshuPath, false); err != nil {
+			slog.Error("Failed to initialize config", "error", err)
+			os.Exit(1)
+		}
 	}
 
 	cfg, err := config.LoadConfig(wangshuPath)
 	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			slog.Error("Config file not found, run `wangshu-manager init` or start with -init to create one", "path", wangshuPath)
+			os.Exit(1)
+		}
 		slog.Error("Failed to load config", "error", err)
 		os.Exit(1)
 	}
 
-	server, err := NewServer(cfg, wangshuPath)
+	server, err := NewServer(cfg, wangshuPath, *staticDir)
 	if err != nil {
 		slog.Error("Failed to create server", "error", err)
 		os.Exit(1)
 	}
 
+	server.processManager.SetKeepOnExit(*onExit == wangshuOnExitKeep)
 	if err := server.processManager.AutoStartIfNotRunning(); err != nil {
 		slog.Warn("Failed to auto-start wangshu instance", "error", err)
 	}
 
-	if err := server.Start(); err != nil {
-		slog.Error("Server error", "error", err)
-		os.Exit(1)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	serverErr := make(chan error, 1)
+	go func() { serverErr <- server.Start() }()
+
+	select {
+	case err := <-serverErr:
+		stop()
+		if err != nil {
+			slog.Error("Server error", "error", err)
+			os.Exit(1)
+		}
+		return
+	case <-ctx.Done():
+	}
+	// A second signal terminates the process right away.
+	stop()
+	slog.Info("Shutdown signal received", "timeout", *shutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Server did not shut down cleanly", "error", err)
+	}
+	cancel()
+
+	if *onExit == wangshuOnExitKeep {
+		slog.Info("Leaving wangshu running")
+		return
+	}
+	stopCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := server.processManager.Shutdown(stopCtx); err != nil {
+		slog.Warn("Failed to stop wangshu", "error", err)
 	}
 }
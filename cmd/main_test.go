@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// newTestServer returns a server for channels whose web channels are set up
+// as if their listeners were running, without binding any of them.
+func newTestServer(t *testing.T, channels map[string]config.ChannelConfig) *Server {
+	t.Helper()
+	cfg := &config.Config{
+		Agents:   map[string]config.AgentConfig{"default": {Workspace: t.TempDir(), Provider: "p", Model: "m"}},
+		Channels: channels,
+	}
+	s, err := NewServer(cfg, filepath.Join(t.TempDir(), "config.json"), "")
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	s.adminToken = ""
+	s.metricsToken = ""
+	s.backendToken = ""
+	s.webChannels, _ = webListenerChannels(cfg)
+	s.ipFilters = ipFilters(s.webChannels)
+	s.socketRoles = socketRoles(s.webChannels)
+	return s
+}
+
+// serveListener serves s as the listener of channel.
+func serveListener(t *testing.T, s *Server, channel string) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewUnstartedServer(s.listeners.handler)
+	ts.Config.BaseContext = func(net.Listener) context.Context {
+		return context.WithValue(context.Background(), listenerChannelKey{}, channel)
+	}
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func webTestChannel(token string, noAuth bool) config.ChannelConfig {
+	return config.ChannelConfig{
+		Type:     config.ChannelTypeWeb,
+		Enabled:  true,
+		Agent:    "default",
+		Settings: &config.WebSettings{HostAddress: "localhost:18080", Token: token, NoAuth: noAuth},
+	}
+}
+
+// apiStatus makes a request with token, if any, and returns the status.
+func apiStatus(t *testing.T, ts *httptest.Server, method, path, token string) int {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestListenerAuthentication(t *testing.T) {
+	s := newTestServer(t, map[string]config.ChannelConfig{
+		"a":    webTestChannel("token-a", false),
+		"b":    webTestChannel("token-b", false),
+		"open": webTestChannel("", true),
+	})
+	a := serveListener(t, s, "a")
+	open := serveListener(t, s, "open")
+	def := serveListener(t, s, defaultListenerName)
+
+	tests := []struct {
+		name   string
+		ts     *httptest.Server
+		admin  string
+		path   string
+		token  string
+		status int
+	}{
+		{"own channel token", a, "", "/api/v1/listeners", "token-a", http.StatusOK},
+		{"other channel token", a, "", "/api/v1/listeners", "token-b", http.StatusUnauthorized},
+		{"no token", a, "", "/api/v1/listeners", "", http.StatusUnauthorized},
+		{"channel token is operator", a, "", "/api/v1/config", "token-a", http.StatusForbidden},
+		{"admin token", a, "admin-token", "/api/v1/config", "admin-token", http.StatusOK},
+		{"admin token unset", a, "", "/api/v1/config", "admin-token", http.StatusUnauthorized},
+		{"no_auth without token", open, "", "/api/v1/listeners", "", http.StatusOK},
+		{"no_auth is operator", open, "", "/api/v1/config", "", http.StatusForbidden},
+		{"no_auth with admin token", open, "admin-token", "/api/v1/config", "admin-token", http.StatusOK},
+		{"default listener without admin token", def, "", "/api/v1/config", "", http.StatusOK},
+		{"default listener with admin token", def, "admin-token", "/api/v1/config", "", http.StatusUnauthorized},
+		{"default listener rejects channel token", def, "admin-token", "/api/v1/listeners", "token-a", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.adminToken = tt.admin
+			s.limiter = newClientLimiter()
+			if got := apiStatus(t, tt.ts, "GET", tt.path, tt.token); got != tt.status {
+				t.Errorf("GET %s = %d, want %d", tt.path, got, tt.status)
+			}
+		})
+	}
+}
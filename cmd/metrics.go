@@ -0,0 +1,384 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yockii/wangshu-manager/internal/auth"
+)
+
+const (
+	// metricsTokenEnv names the environment variable holding the token
+	// Prometheus scrapes /metrics with. Admin credentials are accepted too.
+	metricsTokenEnv = "WANGSHU_MANAGER_METRICS_TOKEN"
+
+	metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+	directionToWangshu = "to_wangshu"
+	directionToWeb     = "to_web"
+)
+
+// requestDurationBuckets are the upper bounds, in seconds, of the request
+// latency histogram.
+var requestDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// procStats is the resource usage of a process.
+type procStats struct {
+	CPUSeconds    float64
+	ResidentBytes float64
+	VirtualBytes  float64
+	// OpenFDs is -1 when the descriptors could not be counted.
+	OpenFDs   int
+	StartTime time.Time
+}
+
+// metrics holds the counters the manager exposes at /metrics. Gauges such as
+// the number of connected clients are read when the metrics are scraped.
+type metrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]*requestStats
+	forwarded map[string]uint64
+	dropped   map[string]uint64
+
+	authFailures uint64
+	lockouts     uint64
+	// rateLimited counts rejected requests by reason.
+	rateLimited map[string]uint64
+}
+
+type requestKey struct {
+	route  string
+	method string
+}
+
+type requestStats struct {
+	codes map[int]uint64
+	// buckets counts requests per histogram bucket, not cumulatively; the
+	// last entry is the +Inf bucket.
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:    make(map[requestKey]*requestStats),
+		forwarded:   make(map[string]uint64),
+		dropped:     make(map[string]uint64),
+		rateLimited: make(map[string]uint64),
+	}
+}
+
+// observeRequest records a finished request. The latency of hijacked
+// requests, which is the lifetime of a WebSocket connection, is left out of
+// the histogram.
+func (m *metrics) observeRequest(route, method string, status int, d time.Duration, hijacked bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := requestKey{route: route, method: method}
+	st, ok := m.requests[key]
+	if !ok {
+		st = &requestStats{codes: make(map[int]uint64), buckets: make([]uint64, len(requestDurationBuckets)+1)}
+		m.requests[key] = st
+	}
+	st.codes[status]++
+	if hijacked {
+		return
+	}
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(requestDurationBuckets, seconds)
+	st.buckets[i]++
+	st.sum += seconds
+	st.count++
+}
+
+// observeMessage records whether a chat message reached at least one
+// client in direction.
+func (m *metrics) observeMessage(direction string, delivered bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if delivered {
+		m.forwarded[direction]++
+	} else {
+		m.dropped[direction]++
+	}
+}
+
+// observeAuthFailure records a failed authentication and whether it locked
+// the client out.
+func (m *metrics) observeAuthFailure(lockout bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.authFailures++
+	if lockout {
+		m.lockouts++
+	}
+}
+
+// observeRateLimited records a request rejected because its client was
+// locked out or over its rate limit.
+func (m *metrics) observeRateLimited(locked bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if locked {
+		m.rateLimited["lockout"]++
+	} else {
+		m.rateLimited["rate"]++
+	}
+}
+
+// metricsMethod returns the method label of a request. Unknown methods are
+// counted together to keep the number of series bounded.
+func metricsMethod(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS":
+		return method
+	}
+	return "OTHER"
+}
+
+// handleMetrics serves the metrics in the Prometheus text format to callers
+// with the metrics token, sent as a bearer token or like any other token, or
+// with admin credentials.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.limitClient(w, r) {
+		return
+	}
+	authenticated, allowed := s.metricsAuthorized(r)
+	if !authenticated {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+		httpError(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !allowed {
+		httpError(w, r, fmt.Sprintf("Forbidden: %s role required", auth.RoleAdmin), http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", metricsContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	s.writeMetrics(w)
+}
+
+// metricsAuthorized reports whether r carries valid credentials and whether
+// they grant access to the metrics: the metrics token or an admin.
+func (s *Server) metricsAuthorized(r *http.Request) (authenticated, allowed bool) {
+	token := requestToken(r)
+	if s.metricsToken != "" && auth.SecretEqual(token, s.metricsToken) {
+		setIdentity(r, "metrics")
+		s.authSucceeded(r, token)
+		return true, true
+	}
+	p, _, ok := s.authenticateRequest(r)
+	if !ok {
+		if token != "" {
+			s.authFailed(r, token)
+		}
+		return false, false
+	}
+	s.authSucceeded(r, token)
+	setIdentity(r, p.Name)
+	return true, p.Role.Allows(auth.RoleAdmin)
+}
+
+func (s *Server) writeMetrics(w io.Writer) {
+	mw := &metricsWriter{w: w}
+
+	s.metrics.mu.Lock()
+	keys := make([]requestKey, 0, len(s.metrics.requests))
+	for key := range s.metrics.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	mw.header("wangshu_manager_http_requests_total", "counter", "HTTP requests by route, method and status code.")
+	for _, key := range keys {
+		st := s.metrics.requests[key]
+		codes := make([]int, 0, len(st.codes))
+		for code := range st.codes {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			mw.sample("wangshu_manager_http_requests_total", float64(st.codes[code]),
+				"route", key.route, "method", key.method, "code", strconv.Itoa(code))
+		}
+	}
+	mw.header("wangshu_manager_http_request_duration_seconds", "histogram", "Latency of HTTP requests, without WebSocket connections.")
+	for _, key := range keys {
+		st := s.metrics.requests[key]
+		if st.count == 0 {
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range requestDurationBuckets {
+			cumulative += st.buckets[i]
+			mw.sample("wangshu_manager_http_request_duration_seconds_bucket", float64(cumulative),
+				"route", key.route, "method", key.method, "le", formatFloat(bound))
+		}
+		mw.sample("wangshu_manager_http_request_duration_seconds_bucket", float64(st.count),
+			"route", key.route, "method", key.method, "le", "+Inf")
+		mw.sample("wangshu_manager_http_request_duration_seconds_sum", st.sum, "route", key.route, "method", key.method)
+		mw.sample("wangshu_manager_http_request_duration_seconds_count", float64(st.count), "route", key.route, "method", key.method)
+	}
+
+	mw.header("wangshu_manager_messages_forwarded_total", "counter", "Chat messages delivered to at least one client.")
+	for _, direction := range []string{directionToWangshu, directionToWeb} {
+		mw.sample("wangshu_manager_messages_forwarded_total", float64(s.metrics.forwarded[direction]), "direction", direction)
+	}
+	mw.header("wangshu_manager_messages_dropped_total", "counter", "Chat messages no client received.")
+	for _, direction := range []string{directionToWangshu, directionToWeb} {
+		mw.sample("wangshu_manager_messages_dropped_total", float64(s.metrics.dropped[direction]), "direction", direction)
+	}
+
+	mw.header("wangshu_manager_auth_failures_total", "counter", "Failed authentications with a credential.")
+	mw.sample("wangshu_manager_auth_failures_total", float64(s.metrics.authFailures))
+	mw.header("wangshu_manager_auth_lockouts_total", "counter", "Clients locked out after repeated authentication failures.")
+	mw.sample("wangshu_manager_auth_lockouts_total", float64(s.metrics.lockouts))
+	mw.header("wangshu_manager_rate_limited_requests_total", "counter", "Requests rejected with 429 by reason: rate or lockout.")
+	for _, reason := range []string{"lockout", "rate"} {
+		mw.sample("wangshu_manager_rate_limited_requests_total", float64(s.metrics.rateLimited[reason]), "reason", reason)
+	}
+	s.metrics.mu.Unlock()
+
+	mw.header("wangshu_manager_locked_out_clients", "gauge", "Clients that are currently locked out.")
+	mw.sample("wangshu_manager_locked_out_clients", float64(s.limiter.lockedOut(time.Now())))
+
+	webClients, wangshuClients := s.countClients()
+	mw.header("wangshu_manager_websocket_clients", "gauge", "Connected WebSocket clients.")
+	mw.sample("wangshu_manager_websocket_clients", float64(webClients), "kind", "web")
+	mw.sample("wangshu_manager_websocket_clients", float64(wangshuClients), "kind", "wangshu")
+
+	pid, err := s.processManager.FindRunningProcess()
+	up := 0.0
+	if err == nil {
+		up = 1
+	}
+	mw.header("wangshu_instance_up", "gauge", "Whether a wangshu instance is running.")
+	mw.sample("wangshu_instance_up", up)
+
+	stats := s.processManager.Stats()
+	mw.header("wangshu_instance_starts_total", "counter", "wangshu instances started by the manager.")
+	mw.sample("wangshu_instance_starts_total", float64(stats.Starts))
+	mw.header("wangshu_instance_restarts_total", "counter", "Restarts of the wangshu instance.")
+	mw.sample("wangshu_instance_restarts_total", float64(stats.Restarts))
+	mw.header("wangshu_instance_exits_total", "counter", "Exits of wangshu instances started by the manager by exit code, -1 if killed by a signal.")
+	codes := make([]int, 0, len(stats.Exits))
+	for code := range stats.Exits {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		mw.sample("wangshu_instance_exits_total", float64(stats.Exits[code]), "code", strconv.Itoa(code))
+	}
+
+	if err == nil {
+		if ps, err := readProcStats(pid); err == nil {
+			mw.procStats("wangshu_instance", "the wangshu instance", ps)
+		}
+	}
+	if ps, err := readProcStats(os.Getpid()); err == nil {
+		mw.procStats("process", "the manager", ps)
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	mw.header("go_goroutines", "gauge", "Number of goroutines of the manager.")
+	mw.sample("go_goroutines", float64(runtime.NumGoroutine()))
+	mw.header("go_memstats_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects of the manager.")
+	mw.sample("go_memstats_heap_alloc_bytes", float64(mem.HeapAlloc))
+	mw.header("go_memstats_sys_bytes", "gauge", "Bytes of memory the manager obtained from the system.")
+	mw.sample("go_memstats_sys_bytes", float64(mem.Sys))
+	mw.header("go_gc_cycles_total", "counter", "Completed garbage collection cycles of the manager.")
+	mw.sample("go_gc_cycles_total", float64(mem.NumGC))
+}
+
+// countClients returns the number of connected web and wangshu WebSocket
+// clients.
+func (s *Server) countClients() (web, wangshu int) {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	for clientID := range s.clients {
+		switch {
+		case strings.HasPrefix(clientID, webClientPrefix):
+			web++
+		case strings.HasPrefix(clientID, wangshuClientPrefix):
+			wangshu++
+		}
+	}
+	return web, wangshu
+}
+
+// metricsWriter writes the Prometheus text exposition format.
+type metricsWriter struct {
+	w io.Writer
+}
+
+func (mw *metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one sample; labels alternate names and values.
+func (mw *metricsWriter) sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(labelEscaper.Replace(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(value))
+	b.WriteByte('\n')
+	io.WriteString(mw.w, b.String())
+}
+
+func (mw *metricsWriter) procStats(prefix, of string, ps procStats) {
+	mw.header(prefix+"_cpu_seconds_total", "counter", "User and system CPU time of "+of+" in seconds.")
+	mw.sample(prefix+"_cpu_seconds_total", ps.CPUSeconds)
+	mw.header(prefix+"_resident_memory_bytes", "gauge", "Resident memory of "+of+" in bytes.")
+	mw.sample(prefix+"_resident_memory_bytes", ps.ResidentBytes)
+	mw.header(prefix+"_virtual_memory_bytes", "gauge", "Virtual memory of "+of+" in bytes.")
+	mw.sample(prefix+"_virtual_memory_bytes", ps.VirtualBytes)
+	if ps.OpenFDs >= 0 {
+		mw.header(prefix+"_open_fds", "gauge", "Open file descriptors of "+of+".")
+		mw.sample(prefix+"_open_fds", float64(ps.OpenFDs))
+	}
+	if !ps.StartTime.IsZero() {
+		mw.header(prefix+"_start_time_seconds", "gauge", "Start time of "+of+" since the Unix epoch in seconds.")
+		mw.sample(prefix+"_start_time_seconds", float64(ps.StartTime.Unix()))
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
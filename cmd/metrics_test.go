@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/yockii/wangshu-manager/internal/auth"
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+func TestMetricsAuthorization(t *testing.T) {
+	s := newTestServer(t, map[string]config.ChannelConfig{"web": webTestChannel("channel-token", false)})
+	s.adminToken = "admin-token"
+	s.metricsToken = "metrics-token"
+	_, viewer, err := s.tokens.Create("viewer", auth.RoleViewer, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := serveListener(t, s, "web")
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"metrics token", "metrics-token", http.StatusOK},
+		{"admin token", "admin-token", http.StatusOK},
+		{"viewer token", viewer, http.StatusForbidden},
+		{"channel token", "channel-token", http.StatusForbidden},
+		{"wrong token", "wrong-token", http.StatusUnauthorized},
+		{"no token", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		s.limiter = newClientLimiter()
+		if got := apiStatus(t, ts, "GET", "/metrics", tt.token); got != tt.want {
+			t.Errorf("%s: GET /metrics = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yockii/wangshu-manager/internal/auth"
+	"github.com/yockii/wangshu-manager/internal/config"
+	"github.com/yockii/wangshu-manager/internal/process"
+	"github.com/yockii/wangshu-manager/internal/provider"
+)
+
+const (
+	openAPIVersion = "3.0.3"
+	// openAPIPath is the route of the OpenAPI document. It describes every
+	// version of the API, so it is served at /api/openapi.json without being
+	// a deprecated alias.
+	openAPIPath = "openapi.json"
+)
+
+// apiDoc documents an operation of apiRoutes. Request and response bodies
+// are given as Go values whose types are turned into schemas; a
+// map[string]interface{} describes an object with one property per key.
+type apiDoc struct {
+	summary     string
+	description string
+	params      []apiParam
+	body        interface{}
+	// response is the data of a successful response, which the versioned API
+	// wraps in the envelope.
+	response interface{}
+	// status is the status of a successful response, 200 if zero.
+	status int
+	// contentType is set for documents and downloads that are served as is
+	// instead of in the envelope.
+	contentType string
+	// errors lists the error statuses of the operation besides the rate
+	// limit and the authentication errors every protected operation may
+	// return.
+	errors []int
+}
+
+// apiParam is a query, header or path parameter. Path parameters are taken
+// from the route pattern and only need an entry for their description.
+type apiParam struct {
+	in          string
+	name        string
+	typ         string
+	enum        []string
+	required    bool
+	description string
+}
+
+// apiDocs documents apiRoutes, keyed by method and pattern. Every operation
+// of apiRoutes must have an entry.
+var apiDocs = map[string]apiDoc{
+	"POST login": {
+		summary:     "Log in",
+		description: "Exchanges a token, or a username and password, for a session cookie. Requests made with the cookie that change state must send csrf_token in the X-CSRF-Token header.",
+		body: struct {
+			Token    string `json:"token,omitempty"`
+			Username string `json:"username,omitempty"`
+			Password string `json:"password,omitempty"`
+		}{},
+		response: sessionInfoDoc,
+		errors:   []int{http.StatusBadRequest, http.StatusUnauthorized},
+	},
+	"POST logout": {
+		summary:  "Log out",
+		response: map[string]interface{}{"success": true},
+	},
+	"GET session": {
+		summary:     "Describe the caller",
+		description: "csrf_token and expires_at are only present for callers authenticated with a session cookie.",
+		response:    sessionInfoDoc,
+	},
+	"GET sessions": {
+		summary:  "List the chat sessions of an agent",
+		params:   []apiParam{agentParam},
+		response: map[string]interface{}{"sessions": []Session{}},
+		errors:   []int{http.StatusBadRequest, http.StatusNotFound},
+	},
+	"GET tasks": {
+		summary:  "List the tasks of an agent",
+		params:   []apiParam{agentParam},
+		response: map[string]interface{}{"tasks": []TaskInfo{}},
+		errors:   []int{http.StatusBadRequest, http.StatusNotFound},
+	},
+	"GET cron": {
+		summary:  "List the cron jobs of an agent",
+		params:   []apiParam{agentParam},
+		response: map[string]interface{}{"cronJobs": []CronJob{}},
+		errors:   []int{http.StatusBadRequest, http.StatusNotFound},
+	},
+	"GET config": {
+		summary:  "Get the config",
+		response: map[string]interface{}{"config": config.Config{}},
+	},
+	"PUT config": {
+		summary:     "Replace the config",
+		description: "The config is validated before it is saved. A config with errors is rejected with validation_failed and the validation result in the error details.",
+		body:        config.Config{},
+		response:    map[string]interface{}{"success": true, "validation": config.ValidationResult{}},
+		errors:      []int{http.StatusBadRequest, http.StatusUnprocessableEntity},
+	},
+	"POST config/diff": {
+		summary:  "Compare a config with the current one",
+		body:     config.Config{},
+		response: map[string]interface{}{"diff": config.ConfigDiff{}, "validation": config.ValidationResult{}},
+		errors:   []int{http.StatusBadRequest},
+	},
+	"GET config/schema": {
+		summary:     "Get the JSON Schema of the config",
+		response:    map[string]interface{}{},
+		contentType: "application/schema+json",
+	},
+	"GET config/channel-types": {
+		summary:  "List the registered channel types and their fields",
+		response: map[string]interface{}{"channelTypes": []config.ChannelTypeInfo{}},
+	},
+	"GET config/export": {
+		summary: "Export the config as a bundle",
+		params: []apiParam{
+			{in: "query", name: "skills", typ: "boolean", description: "Include the skill files."},
+			passphraseParam,
+		},
+		response:    config.Bundle{},
+		contentType: "application/json",
+	},
+	"POST config/import": {
+		summary: "Import a config bundle",
+		params: []apiParam{
+			{in: "query", name: "mode", typ: "string", enum: []string{config.ImportMerge, config.ImportReplace}},
+			{in: "query", name: "on_conflict", typ: "string", enum: []string{config.ConflictSkip, config.ConflictOverwrite}},
+			{in: "query", name: "preview", typ: "boolean", description: "Report what the import would change without applying it."},
+			passphraseParam,
+		},
+		body:     config.Bundle{},
+		response: map[string]interface{}{"applied": true, "report": config.ImportReport{}},
+		errors:   []int{http.StatusBadRequest, http.StatusUnprocessableEntity},
+	},
+	"GET instance": {
+		summary:  "Get the status of the wangshu instance",
+		response: map[string]interface{}{"status": process.InstanceStatus{}},
+	},
+	"POST instance": {
+		summary: "Start, stop or restart the wangshu instance",
+		params: []apiParam{
+			{in: "query", name: "action", typ: "string", required: true, enum: []string{"start", "stop", "restart"}},
+		},
+		response: map[string]interface{}{"success": true, "message": ""},
+		errors:   []int{http.StatusBadRequest},
+	},
+	"GET listeners": {
+		summary:  "List the web channel listeners",
+		response: map[string]interface{}{"listeners": []ListenerStatus{}},
+	},
+	"GET audit": {
+		summary: "List recent audit log entries, newest first",
+		params: []apiParam{
+			{in: "query", name: "limit", typ: "integer", description: fmt.Sprintf("Number of entries, %d by default and at most %d.", defaultAuditLimit, maxAuditLimit)},
+		},
+		response: map[string]interface{}{"entries": []AuditEntry{}},
+		errors:   []int{http.StatusBadRequest},
+	},
+	"POST providers/{name}/test": {
+		summary:     "Test the connection to a provider",
+		description: "A failed test is reported in the result with a 200 response.",
+		params:      []apiParam{providerParam},
+		response:    map[string]interface{}{"result": provider.TestResult{}},
+		errors:      []int{http.StatusNotFound},
+	},
+	"GET providers/{name}/models": {
+		summary:     "List the models of a provider",
+		description: "A failed request to the provider is rejected with upstream_error and the test result in the error details.",
+		params: []apiParam{
+			providerParam,
+			{in: "query", name: "refresh", typ: "boolean", description: "Bypass the model cache."},
+		},
+		response: map[string]interface{}{"models": provider.ModelList{}},
+		errors:   []int{http.StatusNotFound, http.StatusBadGateway},
+	},
+	"GET tokens": {
+		summary:  "List API tokens",
+		response: map[string]interface{}{"tokens": []auth.Token{}},
+	},
+	"POST tokens": {
+		summary:     "Create an API token",
+		description: "The secret is only returned by this request.",
+		body: struct {
+			Name      string    `json:"name"`
+			Role      auth.Role `json:"role"`
+			ExpiresIn string    `json:"expires_in,omitempty"`
+		}{},
+		response: map[string]interface{}{"token": auth.Token{}, "secret": ""},
+		status:   http.StatusCreated,
+		errors:   []int{http.StatusBadRequest},
+	},
+	"DELETE tokens/{id}": {
+		summary:  "Revoke an API token",
+		params:   []apiParam{{in: "path", name: "id", description: "ID of the token."}},
+		response: map[string]interface{}{"success": true, "token": auth.Token{}},
+		errors:   []int{http.StatusNotFound},
+	},
+	"GET users": {
+		summary:  "List user accounts",
+		response: map[string]interface{}{"users": []auth.User{}},
+	},
+	"POST users": {
+		summary: "Create a user account",
+		body: struct {
+			Username string    `json:"username"`
+			Password string    `json:"password"`
+			Role     auth.Role `json:"role"`
+		}{},
+		response: map[string]interface{}{"user": auth.User{}},
+		status:   http.StatusCreated,
+		errors:   []int{http.StatusBadRequest, http.StatusConflict},
+	},
+	"PUT users/{name}": {
+		summary:     "Change the role or password of a user account",
+		description: "Fields that are left out keep their value.",
+		params:      []apiParam{userParam},
+		body: struct {
+			Password string    `json:"password,omitempty"`
+			Role     auth.Role `json:"role,omitempty"`
+		}{},
+		response: map[string]interface{}{"user": auth.User{}},
+		errors:   []int{http.StatusBadRequest, http.StatusNotFound},
+	},
+	"DELETE users/{name}": {
+		summary:  "Delete a user account",
+		params:   []apiParam{userParam},
+		response: map[string]interface{}{"success": true},
+		errors:   []int{http.StatusNotFound},
+	},
+	"GET " + openAPIPath: {
+		summary:     "Get this OpenAPI document",
+		response:    map[string]interface{}{},
+		contentType: "application/json",
+	},
+}
+
+var (
+	sessionInfoDoc = map[string]interface{}{
+		"name":       "",
+		"role":       auth.Role(""),
+		"csrf_token": "",
+		"expires_at": time.Time{},
+	}
+
+	agentParam      = apiParam{in: "query", name: "agent", typ: "string", required: true, description: "Name of the agent."}
+	providerParam   = apiParam{in: "path", name: "name", description: "Name of the provider."}
+	userParam       = apiParam{in: "path", name: "name", description: "Username."}
+	passphraseParam = apiParam{in: "header", name: "X-Bundle-Passphrase", typ: "string", description: "Passphrase the secrets of the bundle are encrypted with. Without it secrets are left out."}
+)
+
+// openAPISchema is the subset of the OpenAPI schema object the document
+// uses.
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+}
+
+var (
+	openAPIDocument     []byte
+	openAPIDocumentErr  error
+	openAPIDocumentOnce sync.Once
+)
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	openAPIDocumentOnce.Do(func() {
+		openAPIDocument, openAPIDocumentErr = json.MarshalIndent(buildOpenAPI(), "", "  ")
+	})
+	if openAPIDocumentErr != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, "Failed to build OpenAPI document")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
+
+// buildOpenAPI describes apiRoutes as they are served under /api/v1.
+func buildOpenAPI() map[string]interface{} {
+	g := &openAPIGenerator{schemas: make(map[string]*openAPISchema), names: make(map[string]reflect.Type)}
+	g.schemas["Role"] = &openAPISchema{
+		Type: "string",
+		Enum: []string{string(auth.RoleViewer), string(auth.RoleOperator), string(auth.RoleAdmin)},
+	}
+	g.schemas["Error"] = &openAPISchema{
+		Type:     "object",
+		Required: []string{"error"},
+		Properties: map[string]*openAPISchema{
+			"error": {
+				Type:     "object",
+				Required: []string{"code", "message"},
+				Properties: map[string]*openAPISchema{
+					"code": {Type: "string", Enum: []string{
+						codeBadRequest, codeUnauthorized, codeForbidden, codeCSRFFailed, codeOriginNotAllowed,
+						codeNotFound, codeMethodNotAllowed, codeConflict, codeValidationFailed,
+						codeRateLimited, codeUpstreamError, codeInternalError,
+					}},
+					"message":    {Type: "string"},
+					"details":    {Description: "Further information on the error, such as the validation result."},
+					"request_id": {Type: "string", Description: "ID of the request, also sent in the X-Request-ID header."},
+				},
+			},
+		},
+	}
+
+	paths := make(map[string]map[string]interface{})
+	for _, route := range apiRoutes {
+		item := make(map[string]interface{})
+		for method, op := range route.operations {
+			doc := apiDocs[method+" "+route.pattern]
+			item[strings.ToLower(method)] = g.operation(route.pattern, op, doc)
+		}
+		paths["/"+route.pattern] = item
+	}
+
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":       "wangshu-manager API",
+			"version":     strings.TrimSuffix(apiV1Prefix, "/"),
+			"description": "Responses are wrapped in an envelope: {\"data\": ...} on success and {\"error\": {\"code\", \"message\", \"details\"}} otherwise. The routes are also served without the version prefix under /api as deprecated aliases that return the data without the envelope. Every response carries an X-Request-ID header, taken from the request when it sent one.",
+		},
+		"servers": []map[string]string{{"url": "/api/" + strings.TrimSuffix(apiV1Prefix, "/")}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]interface{}{
+				"token": map[string]string{
+					"type": "apiKey", "in": "header", "name": "Authorization",
+					"description": "An API token, the token of the web channel or the admin token, without a scheme.",
+				},
+				"tokenQuery": map[string]string{
+					"type": "apiKey", "in": "query", "name": "token",
+				},
+				"session": map[string]string{
+					"type": "apiKey", "in": "cookie", "name": sessionCookie,
+					"description": "Session cookie set by POST /login. Its name ends in _<port> of the listener.",
+				},
+			},
+		},
+	}
+}
+
+type openAPIGenerator struct {
+	schemas map[string]*openAPISchema
+	names   map[string]reflect.Type
+}
+
+func (g *openAPIGenerator) operation(pattern string, op apiOperation, doc apiDoc) map[string]interface{} {
+	o := map[string]interface{}{
+		"summary": doc.summary,
+		"tags":    []string{strings.Split(pattern, "/")[0]},
+	}
+	description := doc.description
+	if op.role != "" {
+		o["x-required-role"] = op.role
+		description = strings.TrimSpace(fmt.Sprintf("Requires the %s role. %s", op.role, description))
+		o["security"] = []map[string][]string{{"token": {}}, {"tokenQuery": {}}, {"session": {}}}
+	}
+	if description != "" {
+		o["description"] = description
+	}
+
+	if params := g.parameters(pattern, doc.params); len(params) > 0 {
+		o["parameters"] = params
+	}
+	if doc.body != nil {
+		o["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  jsonContent("application/json", g.schema(reflect.TypeOf(doc.body))),
+		}
+	}
+
+	status := doc.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	var data *openAPISchema
+	if m, ok := doc.response.(map[string]interface{}); ok {
+		data = g.object(m)
+	} else {
+		data = g.schema(reflect.TypeOf(doc.response))
+	}
+	contentType, body := doc.contentType, data
+	if contentType == "" {
+		contentType = "application/json"
+		body = &openAPISchema{
+			Type:       "object",
+			Required:   []string{"data"},
+			Properties: map[string]*openAPISchema{"data": data},
+		}
+	}
+	responses := map[string]interface{}{
+		fmt.Sprint(status): map[string]interface{}{
+			"description": http.StatusText(status),
+			"content":     jsonContent(contentType, body),
+		},
+	}
+	statuses := append([]int(nil), doc.errors...)
+	if op.role != "" {
+		statuses = append(statuses, http.StatusUnauthorized, http.StatusForbidden)
+	}
+	statuses = append(statuses, http.StatusTooManyRequests)
+	for _, code := range statuses {
+		responses[fmt.Sprint(code)] = map[string]interface{}{
+			"description": http.StatusText(code),
+			"content":     jsonContent("application/json", &openAPISchema{Ref: "#/components/schemas/Error"}),
+		}
+	}
+	o["responses"] = responses
+	return o
+}
+
+func (g *openAPIGenerator) parameters(pattern string, params []apiParam) []map[string]interface{} {
+	var out []map[string]interface{}
+	for _, seg := range strings.Split(pattern, "/") {
+		if !strings.HasPrefix(seg, "{") {
+			continue
+		}
+		name := strings.Trim(seg, "{}")
+		p := map[string]interface{}{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   &openAPISchema{Type: "string"},
+		}
+		for _, param := range params {
+			if param.in == "path" && param.name == name && param.description != "" {
+				p["description"] = param.description
+			}
+		}
+		out = append(out, p)
+	}
+	for _, param := range params {
+		if param.in == "path" {
+			continue
+		}
+		p := map[string]interface{}{
+			"name":   param.name,
+			"in":     param.in,
+			"schema": &openAPISchema{Type: param.typ, Enum: param.enum},
+		}
+		if param.required {
+			p["required"] = true
+		}
+		if param.description != "" {
+			p["description"] = param.description
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// object describes a response built as a map, with one property per key.
+func (g *openAPIGenerator) object(m map[string]interface{}) *openAPISchema {
+	s := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+	for name, v := range m {
+		s.Properties[name] = g.schema(reflect.TypeOf(v))
+	}
+	return s
+}
+
+// schema maps a Go type onto a schema using its json tags. Named structs are
+// added to components and referred to; fields without omitempty are
+// required.
+func (g *openAPIGenerator) schema(t reflect.Type) *openAPISchema {
+	switch {
+	case t == nil:
+		return &openAPISchema{}
+	case t == reflect.TypeOf(time.Time{}):
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	case t == reflect.TypeOf(auth.Role("")):
+		return &openAPISchema{Ref: "#/components/schemas/Role"}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := t.Name()
+		if other, ok := g.names[name]; ok && other != t {
+			name = strings.ReplaceAll(t.String(), ".", "")
+		}
+		if _, ok := g.schemas[name]; !ok {
+			// Reserve the name first so recursive types end in a reference.
+			g.names[name] = t
+			g.schemas[name] = nil
+			g.schemas[name] = g.structSchema(t)
+		}
+		return &openAPISchema{Ref: "#/components/schemas/" + name}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &openAPISchema{Type: "string", Format: "byte"}
+		}
+		return &openAPISchema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &openAPISchema{Type: "integer"}
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	default:
+		return &openAPISchema{}
+	}
+}
+
+func (g *openAPIGenerator) structSchema(t reflect.Type) *openAPISchema {
+	s := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = g.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	sort.Strings(s.Required)
+	return s
+}
+
+func jsonContent(contentType string, schema *openAPISchema) map[string]interface{} {
+	return map[string]interface{}{
+		contentType: map[string]interface{}{"schema": schema},
+	}
+}
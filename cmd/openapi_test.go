@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	documented := make(map[string]bool)
+	for _, route := range apiRoutes {
+		for method := range route.operations {
+			key := method + " " + route.pattern
+			documented[key] = true
+			doc, ok := apiDocs[key]
+			if !ok {
+				t.Errorf("%s /api/v1/%s is not documented in apiDocs", method, route.pattern)
+				continue
+			}
+			if doc.summary == "" {
+				t.Errorf("%s has no summary", key)
+			}
+			for _, param := range doc.params {
+				if param.in == "path" && !strings.Contains(route.pattern, "{"+param.name+"}") {
+					t.Errorf("%s documents path parameter %q that is not in the pattern", key, param.name)
+				}
+			}
+		}
+	}
+	for key := range apiDocs {
+		if !documented[key] {
+			t.Errorf("apiDocs documents %s, which is not a route", key)
+		}
+	}
+
+	data, err := json.Marshal(buildOpenAPI())
+	if err != nil {
+		t.Fatalf("failed to encode OpenAPI document: %v", err)
+	}
+	var doc struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("failed to decode OpenAPI document: %v", err)
+	}
+	for _, route := range apiRoutes {
+		for method := range route.operations {
+			if _, ok := doc.Paths["/"+route.pattern][strings.ToLower(method)]; !ok {
+				t.Errorf("OpenAPI document is missing %s /%s", method, route.pattern)
+			}
+		}
+	}
+	for _, name := range []string{"Session", "Message", "ToolCall", "TaskInfo", "CronJob", "InstanceStatus", "Error"} {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("OpenAPI document is missing schema %s", name)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+func TestSocketRolesByPeerUID(t *testing.T) {
+	dir, err := os.MkdirTemp("", "wsm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "wsm.sock")
+
+	tests := []struct {
+		name   string
+		roles  []string
+		path   string
+		status int
+	}{
+		{"admin uid reads config", []string{fmt.Sprintf("%d:admin", os.Getuid())}, "/api/v1/config", http.StatusOK},
+		{"viewer uid reads listeners", []string{fmt.Sprintf("%d:viewer", os.Getuid())}, "/api/v1/listeners", http.StatusOK},
+		{"viewer uid cannot read config", []string{fmt.Sprintf("%d:viewer", os.Getuid())}, "/api/v1/config", http.StatusForbidden},
+		{"other uid", []string{fmt.Sprintf("%d:admin", os.Getuid()+1)}, "/api/v1/listeners", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]config.ChannelConfig{
+				"sock": {Type: config.ChannelTypeWeb, Enabled: true, Agent: "default", Settings: &config.WebSettings{
+					HostAddress: "unix:" + path,
+					SocketRoles: tt.roles,
+				}},
+			})
+			ln, err := listenUnix(path, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := &http.Server{
+				Handler: s.listeners.handler,
+				BaseContext: func(net.Listener) context.Context {
+					return context.WithValue(context.Background(), listenerChannelKey{}, "sock")
+				},
+				ConnContext: peerConnContext,
+			}
+			go srv.Serve(ln)
+			defer srv.Close()
+
+			client := &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", path)
+				},
+			}}
+			resp, err := client.Get("http://localhost" + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("GET %s = %d, want %d", tt.path, resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+const peerCredSupported = false
+
+func peerUID(conn *net.UnixConn) (int, error) {
+	return 0, errors.New("peer credentials are not supported on this platform")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// userHZ is the unit of the cpu times in /proc/<pid>/stat. It is 100 on
+// every architecture Go supports.
+const userHZ = 100
+
+// readProcStats reads the resource usage of the process pid from /proc.
+func readProcStats(pid int) (procStats, error) {
+	dir := fmt.Sprintf("/proc/%d", pid)
+	data, err := os.ReadFile(dir + "/stat")
+	if err != nil {
+		return procStats{}, err
+	}
+	// The command name in parentheses may contain spaces; the fields that
+	// follow it start with the state, field 3.
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return procStats{}, fmt.Errorf("malformed %s/stat", dir)
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 22 {
+		return procStats{}, fmt.Errorf("malformed %s/stat", dir)
+	}
+	field := func(n int) float64 {
+		v, _ := strconv.ParseFloat(fields[n-3], 64)
+		return v
+	}
+
+	stats := procStats{
+		CPUSeconds:    (field(14) + field(15)) / userHZ,
+		VirtualBytes:  field(23),
+		ResidentBytes: field(24) * float64(os.Getpagesize()),
+		OpenFDs:       -1,
+	}
+	if bootTime, err := readBootTime(); err == nil {
+		stats.StartTime = bootTime.Add(time.Duration(field(22) / userHZ * float64(time.Second)))
+	}
+	if fds, err := os.ReadDir(dir + "/fd"); err == nil {
+		stats.OpenFDs = len(fds)
+	}
+	return stats, nil
+}
+
+func readBootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(scanner.Text(), "btime "); ok {
+			sec, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(sec, 0), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, fmt.Errorf("btime not found in /proc/stat")
+}
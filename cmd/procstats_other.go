@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func readProcStats(pid int) (procStats, error) {
+	return procStats{}, errors.New("process statistics are not supported on this platform")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sort"
+
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+// routeProvider returns the provider named in the request path and the
+// models its agents use, or writes a 404.
+func (s *Server) routeProvider(w http.ResponseWriter, r *http.Request) (string, config.ProviderConfig, []string, bool) {
+	name := r.PathValue("name")
+
+	s.cfgMu.RLock()
+	provider, exists := s.cfg.Providers[name]
+	models := agentModels(s.cfg, name)
+	s.cfgMu.RUnlock()
+
+	if !exists {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Provider not found")
+		return "", config.ProviderConfig{}, nil, false
+	}
+	return name, provider, models, true
+}
+
+func (s *Server) testProvider(w http.ResponseWriter, r *http.Request) {
+	name, provider, models, ok := s.routeProvider(w, r)
+	if !ok {
+		return
+	}
+
+	result := s.prober.Test(r.Context(), name, provider, models)
+	if !result.OK {
+		slog.Warn("Provider test failed", "provider", name, "error_class", result.ErrorClass, "error", result.Error)
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"result": result,
+	})
+}
+
+func (s *Server) listProviderModels(w http.ResponseWriter, r *http.Request) {
+	name, provider, _, ok := s.routeProvider(w, r)
+	if !ok {
+		return
+	}
+
+	refresh := r.URL.Query().Get("refresh") == "true"
+	models, result := s.catalog.Models(r.Context(), name, provider, refresh)
+	if result != nil {
+		slog.Warn("Failed to list provider models", "provider", name, "error_class", result.ErrorClass, "error", result.Error)
+		writeErrorDetails(w, r, http.StatusBadGateway, codeUpstreamError, result.Error, map[string]interface{}{
+			"result": result,
+		})
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"models": models,
+	})
+}
+
+// agentModels returns the distinct models the agents of cfg request from
+// the named provider.
+func agentModels(cfg *config.Config, providerName string) []string {
+	seen := make(map[string]bool)
+	var models []string
+	for _, agent := range cfg.Agents {
+		if agent.Provider == providerName && agent.Model != "" && !seen[agent.Model] {
+			seen[agent.Model] = true
+			models = append(models, agent.Model)
+		}
+	}
+	sort.Strings(models)
+	return models
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yockii/wangshu-manager/internal/auth"
+)
+
+const (
+	// clientRate and clientBurst size the token bucket each client draws
+	// from for API requests and WebSocket upgrades.
+	clientRate  = 10
+	clientBurst = 60
+
+	// authFailureLimit is the number of failed authentications in a row
+	// with one credential after which a client is locked out of using it
+	// for authLockoutBase. Every further failure doubles the lockout, up to
+	// authLockoutMax.
+	authFailureLimit = 5
+	authLockoutBase  = 5 * time.Second
+	authLockoutMax   = 15 * time.Minute
+	// authClientFailureLimit is the number of failures with any credential
+	// after which the whole client is locked out, on the same schedule, so
+	// trying a different credential every time does not get around the
+	// lockout. Successes do not clear it, as they may come from another
+	// client behind the same address.
+	authClientFailureLimit = 50
+	// authFailureReset is how long after its last failure a count of
+	// failures starts over.
+	authFailureReset = 30 * time.Minute
+
+	limiterSweepInterval = time.Minute
+)
+
+// clientLimiter rate limits clients and locks out those that keep failing
+// to authenticate. Failures are counted per client and credential, so a
+// client that gets its credential right does not clear the failures of
+// another client behind the same address, and one that keeps getting it
+// wrong does not lock the others out.
+type clientLimiter struct {
+	mu        sync.Mutex
+	clients   map[string]*clientState
+	lastSweep time.Time
+}
+
+type clientState struct {
+	tokens      float64
+	refilled    time.Time
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+func newClientLimiter() *clientLimiter {
+	return &clientLimiter{clients: make(map[string]*clientState)}
+}
+
+// allow takes a token from the bucket of client. When the request has to be
+// rejected it returns how long the client should wait and whether that is
+// because it is locked out.
+func (l *clientLimiter) allow(client string, now time.Time) (ok bool, wait time.Duration, locked bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	st := l.stateLocked(client, now)
+	if now.Before(st.lockedUntil) {
+		return false, st.lockedUntil.Sub(now), true
+	}
+	st.tokens = math.Min(clientBurst, st.tokens+now.Sub(st.refilled).Seconds()*clientRate)
+	st.refilled = now
+	if st.tokens < 1 {
+		return false, time.Duration((1 - st.tokens) / clientRate * float64(time.Second)), false
+	}
+	st.tokens--
+	return true, 0, false
+}
+
+// locked reports whether client is locked out of authenticating with
+// credential and for how long.
+func (l *clientLimiter) locked(client, credential string, now time.Time) (wait time.Duration, locked bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	st, ok := l.clients[credentialKey(client, credential)]
+	if !ok || !now.Before(st.lockedUntil) {
+		return 0, false
+	}
+	return st.lockedUntil.Sub(now), true
+}
+
+// fail records a failed authentication of client with credential and
+// returns the number of failures with it and the lockout it starts, if any.
+func (l *clientLimiter) fail(client, credential string, now time.Time) (failures int, lockout time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	st := l.stateLocked(credentialKey(client, credential), now)
+	failures = st.recordFailure(authFailureLimit, now)
+	lockout = st.lockedUntil.Sub(now)
+
+	st = l.stateLocked(client, now)
+	st.recordFailure(authClientFailureLimit, now)
+	lockout = max(lockout, st.lockedUntil.Sub(now), 0)
+	return failures, lockout
+}
+
+// succeed clears the failed authentications of client with credential.
+func (l *clientLimiter) succeed(client, credential string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if st, ok := l.clients[credentialKey(client, credential)]; ok {
+		st.failures = 0
+		st.lockedUntil = time.Time{}
+	}
+}
+
+// recordFailure counts a failure at now and, once there were limit of them,
+// locks st out as long as authLockout says.
+func (st *clientState) recordFailure(limit int, now time.Time) int {
+	if now.Sub(st.lastFailure) > authFailureReset {
+		st.failures = 0
+	}
+	st.failures++
+	st.lastFailure = now
+	if lockout := authLockout(st.failures, limit); lockout > 0 {
+		st.lockedUntil = now.Add(lockout)
+	}
+	return st.failures
+}
+
+// authLockout returns the lockout after failures failed authentications:
+// none below limit, authLockoutBase at limit, doubling with every further
+// failure up to authLockoutMax.
+func authLockout(failures, limit int) time.Duration {
+	if failures < limit {
+		return 0
+	}
+	lockout := authLockoutBase
+	for i := limit; i < failures && lockout < authLockoutMax; i++ {
+		lockout *= 2
+	}
+	return min(lockout, authLockoutMax)
+}
+
+// lockedOut returns the number of clients that are locked out at now, as a
+// whole or for one of their credentials. A client counts once however many
+// of its credentials are locked out.
+func (l *clientLimiter) lockedOut(now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	clients := make(map[string]bool)
+	for key, st := range l.clients {
+		if now.Before(st.lockedUntil) {
+			client, _, _ := strings.Cut(key, "|")
+			clients[client] = true
+		}
+	}
+	return len(clients)
+}
+
+func (l *clientLimiter) stateLocked(client string, now time.Time) *clientState {
+	if now.Sub(l.lastSweep) > limiterSweepInterval {
+		l.sweepLocked(now)
+	}
+	st, ok := l.clients[client]
+	if !ok {
+		st = &clientState{tokens: clientBurst, refilled: now}
+		l.clients[client] = st
+	}
+	return st
+}
+
+// sweepLocked forgets clients whose bucket is full again and that have no
+// lockout or failures left to remember.
+func (l *clientLimiter) sweepLocked(now time.Time) {
+	l.lastSweep = now
+	refill := time.Duration(float64(clientBurst) / clientRate * float64(time.Second))
+	for client, st := range l.clients {
+		if now.Sub(st.refilled) >= refill && !now.Before(st.lockedUntil) &&
+			(st.failures == 0 || now.Sub(st.lastFailure) > authFailureReset) {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// clientKey identifies the client of r for rate limiting: the peer uid on
+// unix sockets, the IP address otherwise.
+func clientKey(r *http.Request) string {
+	if uid, ok := requestPeerUID(r); ok {
+		return fmt.Sprintf("uid:%d", uid)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return ip.Unmap().String()
+	}
+	return host
+}
+
+// credentialKey identifies client using credential in the limiter. The
+// credential is hashed so the limiter does not keep secrets in memory.
+func credentialKey(client, credential string) string {
+	return client + "|" + auth.HashToken(credential)
+}
+
+// limitClient rejects requests from clients that are locked out, or locked
+// out of the token they send, or have used up their rate limit with a 429
+// and a Retry-After header.
+func (s *Server) limitClient(w http.ResponseWriter, r *http.Request) bool {
+	ok, wait, locked := s.limiter.allow(clientKey(r), time.Now())
+	if !ok {
+		s.rejectClient(w, r, wait, locked)
+		return false
+	}
+	return s.limitCredential(w, r, requestToken(r))
+}
+
+// limitCredential rejects requests from clients that are locked out of
+// authenticating with credential.
+func (s *Server) limitCredential(w http.ResponseWriter, r *http.Request, credential string) bool {
+	if credential == "" {
+		return true
+	}
+	wait, locked := s.limiter.locked(clientKey(r), credential, time.Now())
+	if !locked {
+		return true
+	}
+	s.rejectClient(w, r, wait, true)
+	return false
+}
+
+func (s *Server) rejectClient(w http.ResponseWriter, r *http.Request, wait time.Duration, locked bool) {
+	s.metrics.observeRateLimited(locked)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	message := "Too many requests"
+	if locked {
+		message = "Too many failed authentication attempts"
+	}
+	writeError(w, r, http.StatusTooManyRequests, codeRateLimited, message)
+}
+
+// authFailed records a failed authentication with credential and locks the
+// client out once it failed too often. Requests without a credential do not
+// count, so an expired session cookie cannot lock a browser out.
+func (s *Server) authFailed(r *http.Request, credential string) {
+	client := clientKey(r)
+	failures, lockout := s.limiter.fail(client, credential, time.Now())
+	s.metrics.observeAuthFailure(lockout > 0)
+	if lockout > 0 {
+		slog.Warn("Client locked out after repeated authentication failures",
+			"client", client, "failures", failures, "lockout", lockout, "request_id", requestID(r))
+	}
+}
+
+// authSucceeded clears the failed authentications of the client with
+// credential, if any.
+func (s *Server) authSucceeded(r *http.Request, credential string) {
+	if credential != "" {
+		s.limiter.succeed(clientKey(r), credential)
+	}
+}
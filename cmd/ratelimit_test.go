@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+var limiterEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestAuthLockoutSchedule(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{authFailureLimit - 1, 0},
+		{authFailureLimit, 5 * time.Second},
+		{authFailureLimit + 1, 10 * time.Second},
+		{authFailureLimit + 2, 20 * time.Second},
+		{authFailureLimit + 7, 640 * time.Second},
+		{authFailureLimit + 8, authLockoutMax},
+		{authFailureLimit + 31, authLockoutMax},
+		{authFailureLimit + 32, authLockoutMax},
+		{authFailureLimit + 1000, authLockoutMax},
+	}
+	for _, tt := range tests {
+		if got := authLockout(tt.failures, authFailureLimit); got != tt.want {
+			t.Errorf("authLockout(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestClientLimiterAllow(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests int
+		after    time.Duration
+		ok       bool
+		wait     time.Duration
+	}{
+		{"within burst", clientBurst - 1, 0, true, 0},
+		{"burst used up", clientBurst, 0, false, time.Second / clientRate},
+		{"refilled one token", clientBurst, time.Second / clientRate, true, 0},
+		{"refilled completely", clientBurst * 2, clientBurst / clientRate * time.Second, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newClientLimiter()
+			for i := 0; i < tt.requests; i++ {
+				if ok, _, _ := l.allow("10.0.0.1", limiterEpoch); !ok && i < clientBurst {
+					t.Fatalf("request %d rejected within the burst", i)
+				}
+			}
+			ok, wait, locked := l.allow("10.0.0.1", limiterEpoch.Add(tt.after))
+			if ok != tt.ok || wait != tt.wait || locked {
+				t.Errorf("allow() = %v, %v, %v, want %v, %v, false", ok, wait, locked, tt.ok, tt.wait)
+			}
+			if ok, _, _ := l.allow("10.0.0.2", limiterEpoch); !ok {
+				t.Error("another client was rate limited")
+			}
+		})
+	}
+}
+
+func TestClientLimiterFail(t *testing.T) {
+	tests := []struct {
+		name string
+		// run makes calls to l and returns the time to check at.
+		run func(l *clientLimiter) time.Time
+		// lockedOut lists the client and credential pairs that must be
+		// locked out at that time; every other pair must not be.
+		lockedOut [][2]string
+	}{
+		{"below the limit", func(l *clientLimiter) time.Time {
+			for i := 0; i < authFailureLimit-1; i++ {
+				l.fail("10.0.0.1", "wrong", limiterEpoch)
+			}
+			return limiterEpoch
+		}, nil},
+		{"at the limit", func(l *clientLimiter) time.Time {
+			for i := 0; i < authFailureLimit; i++ {
+				l.fail("10.0.0.1", "wrong", limiterEpoch)
+			}
+			return limiterEpoch.Add(authLockoutBase - time.Millisecond)
+		}, [][2]string{{"10.0.0.1", "wrong"}}},
+		{"lockout expires", func(l *clientLimiter) time.Time {
+			for i := 0; i < authFailureLimit; i++ {
+				l.fail("10.0.0.1", "wrong", limiterEpoch)
+			}
+			return limiterEpoch.Add(authLockoutBase)
+		}, nil},
+		{"failures reset after a quiet period", func(l *clientLimiter) time.Time {
+			for i := 0; i < authFailureLimit-1; i++ {
+				l.fail("10.0.0.1", "wrong", limiterEpoch)
+			}
+			now := limiterEpoch.Add(authFailureReset + time.Second)
+			l.fail("10.0.0.1", "wrong", now)
+			return now
+		}, nil},
+		{"success of another client does not reset failures", func(l *clientLimiter) time.Time {
+			for i := 0; i < authFailureLimit-1; i++ {
+				l.fail("10.0.0.1", "wrong", limiterEpoch)
+				l.succeed("10.0.0.1", "right")
+			}
+			l.fail("10.0.0.1", "wrong", limiterEpoch)
+			return limiterEpoch
+		}, [][2]string{{"10.0.0.1", "wrong"}}},
+		{"success with the same credential resets failures", func(l *clientLimiter) time.Time {
+			for i := 0; i < authFailureLimit-1; i++ {
+				l.fail("10.0.0.1", "wrong", limiterEpoch)
+			}
+			l.succeed("10.0.0.1", "wrong")
+			l.fail("10.0.0.1", "wrong", limiterEpoch)
+			return limiterEpoch
+		}, nil},
+		{"unix peers have their own key", func(l *clientLimiter) time.Time {
+			for i := 0; i < authFailureLimit; i++ {
+				l.fail("uid:1000", "wrong", limiterEpoch)
+			}
+			return limiterEpoch
+		}, [][2]string{{"uid:1000", "wrong"}}},
+	}
+	pairs := [][2]string{
+		{"10.0.0.1", "wrong"}, {"10.0.0.1", "right"}, {"10.0.0.2", "wrong"},
+		{"uid:1000", "wrong"}, {"uid:1001", "wrong"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newClientLimiter()
+			now := tt.run(l)
+			for _, pair := range pairs {
+				want := false
+				for _, locked := range tt.lockedOut {
+					want = want || locked == pair
+				}
+				if _, got := l.locked(pair[0], pair[1], now); got != want {
+					t.Errorf("locked(%s, %s) = %v, want %v", pair[0], pair[1], got, want)
+				}
+			}
+			for _, client := range []string{"10.0.0.1", "10.0.0.2", "uid:1000"} {
+				if ok, _, locked := l.allow(client, now); !ok || locked {
+					t.Errorf("allow(%s) = %v, locked %v, want the client itself to be allowed", client, ok, locked)
+				}
+			}
+		})
+	}
+}
+
+func TestClientLimiterLocksOutCredentialSpraying(t *testing.T) {
+	l := newClientLimiter()
+	var lockout time.Duration
+	for i := 0; i < authClientFailureLimit; i++ {
+		_, lockout = l.fail("10.0.0.1", fmt.Sprintf("guess-%d", i), limiterEpoch)
+	}
+	if lockout != authLockoutBase {
+		t.Errorf("lockout after %d credentials = %v, want %v", authClientFailureLimit, lockout, authLockoutBase)
+	}
+	if ok, wait, locked := l.allow("10.0.0.1", limiterEpoch); ok || !locked || wait != authLockoutBase {
+		t.Errorf("allow() = %v, %v, %v, want false, %v, true", ok, wait, locked, authLockoutBase)
+	}
+	if ok, _, _ := l.allow("10.0.0.2", limiterEpoch); !ok {
+		t.Error("another client was locked out")
+	}
+	if got := l.lockedOut(limiterEpoch); got != 1 {
+		t.Errorf("lockedOut() = %d, want 1", got)
+	}
+}
+
+func TestClientLimiterCountsLockedOutClientsOnce(t *testing.T) {
+	l := newClientLimiter()
+	for i := 0; i < authFailureLimit; i++ {
+		l.fail("10.0.0.1", "wrong", limiterEpoch)
+		l.fail("10.0.0.2", "wrong", limiterEpoch)
+	}
+	if got := l.lockedOut(limiterEpoch); got != 2 {
+		t.Errorf("lockedOut() with locked credentials = %d, want 2", got)
+	}
+	for i := authFailureLimit; i < authClientFailureLimit; i++ {
+		l.fail("10.0.0.1", fmt.Sprintf("guess-%d", i), limiterEpoch)
+	}
+	if _, _, locked := l.allow("10.0.0.1", limiterEpoch); !locked {
+		t.Fatal("10.0.0.1 is not locked out as a whole")
+	}
+	if got := l.lockedOut(limiterEpoch); got != 2 {
+		t.Errorf("lockedOut() with a locked client = %d, want 2", got)
+	}
+}
+
+func TestClientLimiterSweep(t *testing.T) {
+	l := newClientLimiter()
+	l.allow("idle", limiterEpoch)
+	l.fail("failing", "wrong", limiterEpoch)
+	for i := 0; i < authFailureLimit; i++ {
+		l.fail("locked", "wrong", limiterEpoch)
+	}
+
+	refill := time.Duration(clientBurst/clientRate) * time.Second
+	tests := []struct {
+		after time.Duration
+		kept  []string
+	}{
+		{limiterSweepInterval + time.Second, []string{
+			credentialKey("failing", "wrong"), "failing",
+			credentialKey("locked", "wrong"), "locked",
+		}},
+		{authFailureReset + refill + time.Second, nil},
+	}
+	for _, tt := range tests {
+		l.mu.Lock()
+		l.sweepLocked(limiterEpoch.Add(tt.after))
+		if len(l.clients) != len(tt.kept) {
+			t.Errorf("after %v kept %d clients, want %v", tt.after, len(l.clients), tt.kept)
+		}
+		for _, key := range tt.kept {
+			if _, ok := l.clients[key]; !ok {
+				t.Errorf("after %v %q was swept", tt.after, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+func TestLockoutIsPerCredential(t *testing.T) {
+	s := newTestServer(t, map[string]config.ChannelConfig{"web": webTestChannel("channel-token", false)})
+	ts := serveListener(t, s, "web")
+
+	for i := 0; i < authFailureLimit; i++ {
+		if got := apiStatus(t, ts, "GET", "/api/v1/listeners", "wrong-token"); got != http.StatusUnauthorized {
+			t.Fatalf("attempt %d = %d, want %d", i, got, http.StatusUnauthorized)
+		}
+		if got := apiStatus(t, ts, "GET", "/api/v1/listeners", "channel-token"); got != http.StatusOK {
+			t.Fatalf("valid token after %d failures = %d, want %d", i+1, got, http.StatusOK)
+		}
+	}
+	if got := apiStatus(t, ts, "GET", "/api/v1/listeners", "wrong-token"); got != http.StatusTooManyRequests {
+		t.Errorf("wrong token after %d failures = %d, want %d", authFailureLimit, got, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	// maxRequestIDLength bounds the request IDs taken from clients.
+	maxRequestIDLength = 128
+)
+
+// requestInfo is what handlers tell the request middleware about a request.
+type requestInfo struct {
+	id       string
+	route    string
+	identity string
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFrom(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// requestID returns the ID of r, which is also sent in the X-Request-ID
+// response header.
+func requestID(r *http.Request) string {
+	if info := requestInfoFrom(r); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// setRoute names the route r is served by in metrics and logs, replacing
+// the pattern of the mux.
+func setRoute(r *http.Request, route string) {
+	if info := requestInfoFrom(r); info != nil {
+		info.route = route
+	}
+}
+
+// setIdentity records who r was authenticated as for the access log.
+func setIdentity(r *http.Request, identity string) {
+	if info := requestInfoFrom(r); info != nil {
+		info.identity = identity
+	}
+}
+
+// observeRequests assigns every request an ID, taken from the X-Request-ID
+// header when the client sent a usable one, and echoes it in the response.
+// Finished requests are counted in the metrics and written to the access
+// log, labelled with the pattern of routes that matches them.
+func (s *Server) observeRequests(routes *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &requestInfo{id: r.Header.Get(requestIDHeader)}
+		if !validRequestID(info.id) {
+			info.id = newRequestID()
+		}
+		if _, info.route = routes.Handler(r); info.route == "" {
+			info.route = "other"
+		}
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+		w.Header().Set(requestIDHeader, info.id)
+
+		rec := &responseRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		elapsed := time.Since(start)
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		s.metrics.observeRequest(info.route, metricsMethod(r.Method), status, elapsed, rec.hijacked)
+		logRequest(r, info, status, elapsed)
+	})
+}
+
+// logRequest writes the access log entry of a finished request. Probes,
+// metric scrapes and static files are logged at debug level so they do not
+// drown out the rest.
+func logRequest(r *http.Request, info *requestInfo, status int, elapsed time.Duration) {
+	level := slog.LevelInfo
+	switch {
+	case status >= http.StatusInternalServerError:
+		level = slog.LevelError
+	case info.route == "/healthz" || info.route == "/readyz" || info.route == "/metrics" || info.route == "/":
+		level = slog.LevelDebug
+	}
+	slog.Log(r.Context(), level, "HTTP request",
+		"request_id", info.id,
+		"method", r.Method,
+		"route", info.route,
+		"uri", redactedURI(r.URL),
+		"status", status,
+		"duration", elapsed,
+		"remote", r.RemoteAddr,
+		"user", info.identity,
+	)
+}
+
+// redactedURI returns the path and query of u with the values of token
+// parameters replaced.
+func redactedURI(u *url.URL) string {
+	query := u.Query()
+	if _, ok := query["token"]; !ok {
+		return u.RequestURI()
+	}
+	for i := range query["token"] {
+		query["token"][i] = "REDACTED"
+	}
+	return u.EscapedPath() + "?" + query.Encode()
+}
+
+// validRequestID reports whether a client supplied request ID is short and
+// made of visible ASCII characters only, so it is safe to log and echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// responseRecorder remembers the status a handler wrote. Unlike
+// statusRecorder it lets WebSocket upgrades take over the connection.
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	hijacked bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	conn, brw, err := h.Hijack()
+	if err == nil {
+		r.hijacked = true
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+func TestPlainTextErrorsCarryRequestID(t *testing.T) {
+	s := newTestServer(t, map[string]config.ChannelConfig{"web": webTestChannel("token", false)})
+	ts := serveListener(t, s, "web")
+
+	for _, path := range []string{"/api/sessions", "/readyz?require=bogus", "/metrics"} {
+		req, err := http.NewRequest("GET", ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(requestIDHeader, "req-123")
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode < 400 {
+			t.Errorf("GET %s = %d, want an error", path, resp.StatusCode)
+		}
+		if !strings.Contains(string(body), "(request ID: req-123)") {
+			t.Errorf("GET %s body = %q, want the request ID", path, body)
+		}
+	}
+}
+
+// levelRecorder records the level of every log entry.
+type levelRecorder struct {
+	levels []slog.Level
+}
+
+func (h *levelRecorder) Enabled(context.Context, slog.Level) bool { return true }
+func (h *levelRecorder) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *levelRecorder) WithGroup(string) slog.Handler            { return h }
+
+func (h *levelRecorder) Handle(_ context.Context, r slog.Record) error {
+	h.levels = append(h.levels, r.Level)
+	return nil
+}
+
+func TestLogRequestLevels(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	tests := []struct {
+		route  string
+		status int
+		level  slog.Level
+	}{
+		{"/api/v1/sessions", http.StatusOK, slog.LevelInfo},
+		{"/api/v1/sessions", http.StatusForbidden, slog.LevelInfo},
+		{"/api/v1/sessions", http.StatusBadGateway, slog.LevelError},
+		{"/healthz", http.StatusOK, slog.LevelDebug},
+		{"/readyz", http.StatusServiceUnavailable, slog.LevelError},
+		{"/metrics", http.StatusOK, slog.LevelDebug},
+		{"/", http.StatusOK, slog.LevelDebug},
+		{"/", http.StatusNotFound, slog.LevelDebug},
+	}
+	for _, tt := range tests {
+		rec := &levelRecorder{}
+		slog.SetDefault(slog.New(rec))
+		r, _ := http.NewRequest("GET", "http://localhost"+tt.route, nil)
+		logRequest(r, &requestInfo{id: "id", route: tt.route}, tt.status, 0)
+		if len(rec.levels) != 1 || rec.levels[0] != tt.level {
+			t.Errorf("logRequest(%s, %d) levels = %v, want %v", tt.route, tt.status, rec.levels, tt.level)
+		}
+	}
+}
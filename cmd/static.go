@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/yockii/wangshu-manager/static"
+)
+
+const staticIndex = "index.html"
+
+// staticFiles serves the web frontend embedded in the binary. Files in the
+// override directory, if one is set, are served in place of the embedded
+// ones, so a custom frontend can replace some or all of them.
+type staticFiles struct {
+	embedded fs.FS
+	override fs.FS
+	// etags holds the content hashes of the embedded files, which have no
+	// modification time to revalidate against.
+	etags map[string]string
+}
+
+func newStaticFiles(overrideDir string) (*staticFiles, error) {
+	sf := &staticFiles{embedded: static.FS, etags: make(map[string]string)}
+	if overrideDir != "" {
+		info, err := os.Stat(overrideDir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", overrideDir)
+		}
+		sf.override = os.DirFS(overrideDir)
+	}
+
+	err := fs.WalkDir(sf.embedded, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(sf.embedded, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		sf.etags[name] = `"` + hex.EncodeToString(sum[:8]) + `"`
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sf, nil
+}
+
+// ServeHTTP serves the file named by the request path. Directories are
+// served their index.html. Paths without a file extension that match no
+// file get the top-level index.html, so frontends can route on the client;
+// other missing files are 404s.
+func (sf *staticFiles) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = staticIndex
+	}
+	f, info, etag, err := sf.open(name)
+	if err == nil && info.IsDir() {
+		f.Close()
+		f, info, etag, err = sf.open(path.Join(name, staticIndex))
+	}
+	if errors.Is(err, fs.ErrNotExist) && path.Ext(name) == "" {
+		f, info, etag, err = sf.open(staticIndex)
+	}
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			httpError(w, r, "404 page not found", http.StatusNotFound)
+			return
+		}
+		httpError(w, r, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		httpError(w, r, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The file names carry no content hash, so browsers must revalidate
+	// every file to pick up a new version; unchanged files cost a 304.
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), content)
+}
+
+// open opens name from the override directory, falling back to the embedded
+// files. The returned ETag is empty for files of the override directory,
+// which are revalidated by modification time instead.
+func (sf *staticFiles) open(name string) (fs.File, fs.FileInfo, string, error) {
+	if sf.override != nil {
+		f, info, err := openStat(sf.override, name)
+		if err == nil {
+			return f, info, "", nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, "", err
+		}
+	}
+	f, info, err := openStat(sf.embedded, name)
+	return f, info, sf.etags[name], err
+}
+
+func openStat(fsys fs.FS, name string) (fs.File, fs.FileInfo, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, info, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yockii/wangshu-manager/internal/auth"
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+// managerDataPath returns the path of a file the manager keeps for itself,
+// next to the wangshu config but out of the file wangshu reads.
+func managerDataPath(wangshuPath, name string) string {
+	return filepath.Join(filepath.Dir(config.ExpandPath(wangshuPath)), "manager", name)
+}
+
+func tokenStorePath(wangshuPath string) string {
+	return managerDataPath(wangshuPath, "tokens.json")
+}
+
+func (s *Server) listTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := s.tokens.List()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to load tokens: %v", err))
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"tokens": tokens,
+	})
+}
+
+func (s *Server) revokeToken(w http.ResponseWriter, r *http.Request) {
+	token, err := s.tokens.Revoke(r.PathValue("id"))
+	if errors.Is(err, auth.ErrTokenNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Token not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to revoke token: %v", err))
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"token":   token,
+	})
+}
+
+func (s *Server) createToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string `json:"name"`
+		Role      string `json:"role"`
+		ExpiresIn string `json:"expires_in"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "Invalid request body")
+		return
+	}
+
+	role, err := auth.ParseRole(req.Role)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	var ttl time.Duration
+	if req.ExpiresIn != "" {
+		if ttl, err = time.ParseDuration(req.ExpiresIn); err != nil || ttl <= 0 {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "expires_in must be a positive duration such as 720h")
+			return
+		}
+	}
+
+	token, secret, err := s.tokens.Create(req.Name, role, ttl)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Failed to create token: %v", err))
+		return
+	}
+
+	writeJSON(w, r, http.StatusCreated, map[string]interface{}{
+		"token":  token,
+		"secret": secret,
+	})
+}
+
+func runToken(args []string) error {
+	usage := fmt.Errorf("usage: wangshu-manager token <create|list|revoke> [flags] [config]")
+	if len(args) == 0 {
+		return usage
+	}
+
+	fs := flag.NewFlagSet("token "+args[0], flag.ContinueOnError)
+	name := fs.String("name", "", "name of the new token")
+	role := fs.String("role", string(auth.RoleViewer), "role of the new token: viewer, operator or admin")
+	expires := fs.Duration("expires", 0, "lifetime of the new token, e.g. 720h; 0 never expires")
+	id := fs.String("id", "", "id of the token to revoke")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfgPath := defaultConfigPath
+	if fs.NArg() > 0 {
+		cfgPath = fs.Arg(0)
+	}
+	store, err := auth.NewTokenStore(tokenStorePath(cfgPath))
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		r, err := auth.ParseRole(*role)
+		if err != nil {
+			return err
+		}
+		token, secret, err := store.Create(*name, r, *expires)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created %s token %q (id %s)\n", token.Role, token.Name, token.ID)
+		fmt.Println("Token:", secret)
+		fmt.Println("It is not shown again, store it now.")
+	case "list":
+		tokens, err := store.List()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tROLE\tCREATED\tEXPIRES\tSTATE")
+		now := time.Now()
+		for _, t := range tokens {
+			expiresAt := "never"
+			if t.ExpiresAt != nil {
+				expiresAt = t.ExpiresAt.Local().Format(time.DateTime)
+			}
+			state := "active"
+			switch {
+			case t.RevokedAt != nil:
+				state = "revoked"
+			case !t.Active(now):
+				state = "expired"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", t.ID, t.Name, t.Role, t.CreatedAt.Local().Format(time.DateTime), expiresAt, state)
+		}
+		return tw.Flush()
+	case "revoke":
+		if *id == "" {
+			return fmt.Errorf("-id is required")
+		}
+		token, err := store.Revoke(*id)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Revoked token %q (id %s)\n", token.Name, token.ID)
+	default:
+		return usage
+	}
+	return nil
+}
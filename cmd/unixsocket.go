@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/yockii/wangshu-manager/internal/auth"
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+const staleSocketDialTimeout = time.Second
+
+type peerUIDKey struct{}
+
+// socketRoles returns the parsed socket_roles of every channel that has them.
+func socketRoles(channels map[string]config.ChannelConfig) map[string]map[int]auth.Role {
+	roles := make(map[string]map[int]auth.Role)
+	for name, channel := range channels {
+		web, _ := channel.Web()
+		if !web.PeerAuth() {
+			continue
+		}
+		m, err := web.SocketRoleMap()
+		if err != nil {
+			continue
+		}
+		roles[name] = make(map[int]auth.Role, len(m))
+		for uid, role := range m {
+			if r, err := auth.ParseRole(role); err == nil {
+				roles[name][uid] = r
+			}
+		}
+	}
+	return roles
+}
+
+// listenUnix listens on the unix socket at path with the given permissions.
+// A socket file left behind by a manager that did not shut down cleanly is
+// removed first; one that still accepts connections is left alone.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return ln, nil
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, staleSocketDialTimeout); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	slog.Info("Removing stale socket", "path", path)
+	return os.Remove(path)
+}
+
+// peerConnContext records the uid of the client of a unix socket connection
+// in the context of its requests.
+func peerConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	uid, err := peerUID(uc)
+	if err != nil {
+		slog.Debug("Failed to read peer credentials", "error", err)
+		return ctx
+	}
+	return context.WithValue(ctx, peerUIDKey{}, uid)
+}
+
+// requestPeerUID returns the uid of the client of r if it connected over a
+// unix socket.
+func requestPeerUID(r *http.Request) (int, bool) {
+	uid, ok := r.Context().Value(peerUIDKey{}).(int)
+	return uid, ok
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/yockii/wangshu-manager/internal/auth"
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+func TestSocketRoleNamesAreRoles(t *testing.T) {
+	for _, name := range config.SocketRoleNames {
+		if _, err := auth.ParseRole(name); err != nil {
+			t.Errorf("socket role %q: %v", name, err)
+		}
+	}
+}
+
+func TestSocketRoles(t *testing.T) {
+	channels := map[string]config.ChannelConfig{
+		"sock": {Type: config.ChannelTypeWeb, Enabled: true, Settings: &config.WebSettings{
+			HostAddress: "unix:/run/wsm.sock",
+			SocketRoles: []string{"1000:admin", "1001:viewer"},
+		}},
+		"tcp": webTestChannel("token", false),
+	}
+	roles := socketRoles(channels)
+	if _, ok := roles["tcp"]; ok {
+		t.Error("socketRoles returned roles for a TCP listener")
+	}
+	want := map[int]auth.Role{1000: auth.RoleAdmin, 1001: auth.RoleViewer}
+	if len(roles["sock"]) != len(want) {
+		t.Fatalf("socketRoles()[sock] = %v, want %v", roles["sock"], want)
+	}
+	for uid, role := range want {
+		if roles["sock"][uid] != role {
+			t.Errorf("socketRoles()[sock][%d] = %q, want %q", uid, roles["sock"][uid], role)
+		}
+	}
+}
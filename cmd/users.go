@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yockii/wangshu-manager/internal/auth"
+	"golang.org/x/term"
+)
+
+func userStorePath(wangshuPath string) string {
+	return managerDataPath(wangshuPath, "users.json")
+}
+
+func (s *Server) listUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := s.users.List()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to load users: %v", err))
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"users": users,
+	})
+}
+
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "Invalid request body")
+		return
+	}
+	user, err := s.users.Create(req.Username, req.Password, auth.Role(req.Role))
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, map[string]interface{}{
+		"user": user,
+	})
+}
+
+func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "Invalid request body")
+		return
+	}
+	user, err := s.users.Update(r.PathValue("name"), auth.Role(req.Role), req.Password)
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"user": user,
+	})
+}
+
+func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
+	if err := s.users.Delete(r.PathValue("name")); err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+func writeUserError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, auth.ErrUserNotFound):
+		writeError(w, r, http.StatusNotFound, codeNotFound, "User not found")
+	case errors.Is(err, auth.ErrUserExists):
+		writeError(w, r, http.StatusConflict, codeConflict, "User already exists")
+	default:
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+	}
+}
+
+func runUser(args []string) error {
+	usage := fmt.Errorf("usage: wangshu-manager user <add|list|passwd|remove> [flags] [config]")
+	if len(args) == 0 {
+		return usage
+	}
+
+	fs := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	name := fs.String("name", "", "username")
+	role := fs.String("role", string(auth.RoleViewer), "role of a new user: viewer, operator or admin")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfgPath := defaultConfigPath
+	if fs.NArg() > 0 {
+		cfgPath = fs.Arg(0)
+	}
+	store, err := auth.NewUserStore(userStorePath(cfgPath))
+	if err != nil {
+		return err
+	}
+	if args[0] != "list" && *name == "" {
+		return fmt.Errorf("-name is required")
+	}
+
+	switch args[0] {
+	case "add":
+		r, err := auth.ParseRole(*role)
+		if err != nil {
+			return err
+		}
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		user, err := store.Create(*name, password, r)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created %s user %q\n", user.Role, user.Username)
+	case "passwd":
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		if _, err := store.Update(*name, "", password); err != nil {
+			return err
+		}
+		fmt.Printf("Password of %q changed\n", *name)
+	case "remove":
+		if err := store.Delete(*name); err != nil {
+			return err
+		}
+		fmt.Printf("Removed user %q\n", *name)
+	case "list":
+		users, err := store.List()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "USERNAME\tROLE\tCREATED\tUPDATED")
+		for _, u := range users {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", u.Username, u.Role, u.CreatedAt.Local().Format(time.DateTime), u.UpdatedAt.Local().Format(time.DateTime))
+		}
+		return tw.Flush()
+	default:
+		return usage
+	}
+	return nil
+}
+
+// readPassword reads a password from the first line of standard input, so it
+// can be typed or piped in without showing up in the process list. Typed
+// passwords are not echoed.
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password: %w", err)
+		}
+		return string(password), nil
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,37 @@
+package auth
+
+import "fmt"
+
+// Role is the permission level of a caller. Each role includes the
+// permissions of the roles below it.
+type Role string
+
+const (
+	// RoleViewer may read sessions, tasks, cron jobs and the instance status.
+	RoleViewer Role = "viewer"
+	// RoleOperator may also chat and start, stop or restart the instance.
+	RoleOperator Role = "operator"
+	// RoleAdmin may also read and change the config, including secrets, and
+	// manage API tokens.
+	RoleAdmin Role = "admin"
+)
+
+var roleLevels = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+func ParseRole(s string) (Role, error) {
+	r := Role(s)
+	if _, ok := roleLevels[r]; !ok {
+		return "", fmt.Errorf("unknown role %q, expected viewer, operator or admin", s)
+	}
+	return r, nil
+}
+
+// Allows reports whether r includes the permissions of required.
+func (r Role) Allows(required Role) bool {
+	level, ok := roleLevels[r]
+	return ok && level >= roleLevels[required]
+}
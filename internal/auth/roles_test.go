@@ -0,0 +1,33 @@
+package auth
+
+import "testing"
+
+func TestRoleAllows(t *testing.T) {
+	roles := []Role{RoleViewer, RoleOperator, RoleAdmin}
+	for i, role := range roles {
+		for j, required := range roles {
+			if got, want := role.Allows(required), i >= j; got != want {
+				t.Errorf("%s.Allows(%s) = %v, want %v", role, required, got, want)
+			}
+		}
+	}
+	if Role("root").Allows(RoleViewer) {
+		t.Error("unknown role allows viewer")
+	}
+	if Role("").Allows(RoleViewer) {
+		t.Error("empty role allows viewer")
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	for _, s := range []string{"viewer", "operator", "admin"} {
+		if r, err := ParseRole(s); err != nil || string(r) != s {
+			t.Errorf("ParseRole(%q) = %q, %v", s, r, err)
+		}
+	}
+	for _, s := range []string{"", "Admin", "root"} {
+		if _, err := ParseRole(s); err == nil {
+			t.Errorf("ParseRole(%q) succeeded", s)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Session is a browser login. It remembers which credential it was created
+// with, so callers can end it once that credential is no longer accepted.
+type Session struct {
+	ID   string
+	Name string
+	Role Role
+	// User is set when the session was created with a username and password.
+	User string
+	// TokenID is set when the session was created with an API token.
+	TokenID string
+	// Channel is set when the session was created with a credential that is
+	// only valid on the listener of that channel.
+	Channel string
+	// Credential is the hash of the token, or of the password hash, the
+	// session was created with.
+	Credential string
+	CSRFToken  string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+}
+
+// SessionStore keeps sessions in memory; restarting the manager logs every
+// browser out.
+type SessionStore struct {
+	ttl      time.Duration
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	return &SessionStore{
+		ttl:      ttl,
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Create starts a session from the identity in template. The ID, CSRF token
+// and timestamps of template are ignored.
+func (s *SessionStore) Create(template Session) (Session, error) {
+	id, err := randomHex(32)
+	if err != nil {
+		return Session{}, err
+	}
+	csrf, err := randomHex(32)
+	if err != nil {
+		return Session{}, err
+	}
+
+	now := time.Now()
+	sess := template
+	sess.ID = id
+	sess.CSRFToken = csrf
+	sess.CreatedAt = now
+	sess.ExpiresAt = now.Add(s.ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, other := range s.sessions {
+		if !now.Before(other.ExpiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+	s.sessions[sess.ID] = &sess
+	return sess, nil
+}
+
+// Get returns the session with the given id unless it has expired.
+func (s *SessionStore) Get(id string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+	if !time.Now().Before(sess.ExpiresAt) {
+		delete(s.sessions, id)
+		return Session{}, false
+	}
+	return *sess, true
+}
+
+func (s *SessionStore) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionStore(t *testing.T) {
+	s := NewSessionStore(time.Hour)
+	sess, err := s.Create(Session{ID: "ignored", Name: "user:alice", Role: RoleOperator, User: "alice"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if sess.ID == "ignored" || sess.ID == "" || sess.CSRFToken == "" || sess.CSRFToken == sess.ID {
+		t.Errorf("Create returned ID %q and CSRF token %q", sess.ID, sess.CSRFToken)
+	}
+	if got := sess.ExpiresAt.Sub(sess.CreatedAt); got != time.Hour {
+		t.Errorf("session lifetime = %v, want %v", got, time.Hour)
+	}
+
+	got, ok := s.Get(sess.ID)
+	if !ok || got.Name != "user:alice" || got.Role != RoleOperator || got.CSRFToken != sess.CSRFToken {
+		t.Errorf("Get = %+v, %v", got, ok)
+	}
+	s.Delete(sess.ID)
+	if _, ok := s.Get(sess.ID); ok {
+		t.Error("Get returned a deleted session")
+	}
+}
+
+func TestSessionStoreExpiry(t *testing.T) {
+	s := NewSessionStore(20 * time.Millisecond)
+	sess, err := s.Create(Session{Name: "admin", Role: RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Get(sess.ID); !ok {
+		t.Fatal("session expired early")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := s.Get(sess.ID); ok {
+		t.Error("Get returned an expired session")
+	}
+}
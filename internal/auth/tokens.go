@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenPrefix starts every API token, which makes them easy to tell apart
+// from channel tokens and to spot in logs or leaked files.
+const TokenPrefix = "wsm_"
+
+var ErrTokenNotFound = errors.New("token not found")
+
+// Token is an API token as stored on disk. Only the SHA-256 hash of the
+// secret is kept; the secret itself is shown once, when the token is created.
+type Token struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Role      Role       `json:"role"`
+	Hash      string     `json:"hash,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the token is neither revoked nor expired at now.
+func (t *Token) Active(now time.Time) bool {
+	if t.RevokedAt != nil {
+		return false
+	}
+	return t.ExpiresAt == nil || now.Before(*t.ExpiresAt)
+}
+
+// TokenStore keeps API tokens in a JSON file that only the manager reads.
+// The file is read again whenever it changed on disk, so tokens created with
+// the command line take effect in a running manager.
+type TokenStore struct {
+	file   jsonFile
+	mu     sync.Mutex
+	tokens []*Token
+}
+
+type tokenFile struct {
+	Tokens []*Token `json:"tokens"`
+}
+
+// NewTokenStore loads the tokens stored at path. A missing file is an empty
+// store; it is created on the first change.
+func NewTokenStore(path string) (*TokenStore, error) {
+	s := &TokenStore{file: jsonFile{path: path}}
+	if err := s.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *TokenStore) reloadLocked() error {
+	var f tokenFile
+	changed, err := s.file.reload(&f)
+	if changed {
+		s.tokens = f.Tokens
+	}
+	return err
+}
+
+// Create adds a token and returns it together with its secret. A zero ttl
+// creates a token that does not expire.
+func (s *TokenStore) Create(name string, role Role, ttl time.Duration) (Token, string, error) {
+	if strings.TrimSpace(name) == "" {
+		return Token{}, "", fmt.Errorf("token name is required")
+	}
+	if _, err := ParseRole(string(role)); err != nil {
+		return Token{}, "", err
+	}
+	if ttl < 0 {
+		return Token{}, "", fmt.Errorf("token lifetime must not be negative")
+	}
+
+	id, err := randomHex(6)
+	if err != nil {
+		return Token{}, "", err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return Token{}, "", err
+	}
+	secret = TokenPrefix + secret
+
+	now := time.Now().UTC()
+	t := &Token{
+		ID:        id,
+		Name:      name,
+		Role:      role,
+		Hash:      HashToken(secret),
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		expires := now.Add(ttl)
+		t.ExpiresAt = &expires
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reloadLocked(); err != nil {
+		return Token{}, "", err
+	}
+	s.tokens = append(s.tokens, t)
+	if err := s.saveLocked(); err != nil {
+		s.tokens = s.tokens[:len(s.tokens)-1]
+		return Token{}, "", err
+	}
+	return t.public(), secret, nil
+}
+
+// List returns every token, revoked and expired ones included, without
+// their hashes, newest first.
+func (s *TokenStore) List() ([]Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reloadLocked(); err != nil {
+		return nil, err
+	}
+
+	tokens := make([]Token, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		tokens = append(tokens, t.public())
+	}
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+	return tokens, nil
+}
+
+// Revoke marks the token with the given id as revoked. Revoking a token
+// twice keeps the first revocation time.
+func (s *TokenStore) Revoke(id string) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reloadLocked(); err != nil {
+		return Token{}, err
+	}
+
+	for _, t := range s.tokens {
+		if t.ID != id {
+			continue
+		}
+		if t.RevokedAt == nil {
+			now := time.Now().UTC()
+			t.RevokedAt = &now
+			if err := s.saveLocked(); err != nil {
+				t.RevokedAt = nil
+				return Token{}, err
+			}
+		}
+		return t.public(), nil
+	}
+	return Token{}, ErrTokenNotFound
+}
+
+// Authenticate returns the active token whose secret is secret.
+func (s *TokenStore) Authenticate(secret string) (Token, bool) {
+	if !strings.HasPrefix(secret, TokenPrefix) {
+		return Token{}, false
+	}
+	hash := HashToken(secret)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reloadLocked(); err != nil {
+		slog.Error("Failed to reload API tokens", "error", err)
+	}
+
+	for _, t := range s.tokens {
+		if SecretEqual(t.Hash, hash) && t.Active(now) {
+			return t.public(), true
+		}
+	}
+	return Token{}, false
+}
+
+// Get returns the token with the given id if it is active.
+func (s *TokenStore) Get(id string) (Token, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reloadLocked(); err != nil {
+		slog.Error("Failed to reload API tokens", "error", err)
+	}
+
+	for _, t := range s.tokens {
+		if t.ID == id && t.Active(time.Now()) {
+			return t.public(), true
+		}
+	}
+	return Token{}, false
+}
+
+func (s *TokenStore) saveLocked() error {
+	return s.file.save(tokenFile{Tokens: s.tokens})
+}
+
+func (t *Token) public() Token {
+	cp := *t
+	cp.Hash = ""
+	return cp
+}
+
+// HashToken returns the hex encoded SHA-256 hash of secret.
+func HashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// SecretEqual compares two secrets in constant time. Both are hashed first,
+// so the time taken does not reveal their lengths either.
+func SecretEqual(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	s, err := NewTokenStore(path)
+	if err != nil {
+		t.Fatalf("NewTokenStore: %v", err)
+	}
+
+	token, secret, err := s.Create("ci", RoleOperator, 0)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if !strings.HasPrefix(secret, TokenPrefix) {
+		t.Errorf("secret %q does not start with %q", secret, TokenPrefix)
+	}
+	if token.Hash != "" {
+		t.Error("Create returned the token hash")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), secret) {
+		t.Error("token file contains the secret")
+	}
+	if !strings.Contains(string(data), HashToken(secret)) {
+		t.Error("token file does not contain the hash of the secret")
+	}
+
+	got, ok := s.Authenticate(secret)
+	if !ok || got.ID != token.ID || got.Role != RoleOperator {
+		t.Fatalf("Authenticate = %+v, %v", got, ok)
+	}
+	if _, ok := s.Authenticate(secret + "x"); ok {
+		t.Error("Authenticate accepted a wrong secret")
+	}
+	if _, ok := s.Authenticate(strings.TrimPrefix(secret, TokenPrefix)); ok {
+		t.Error("Authenticate accepted a secret without prefix")
+	}
+
+	// A second store on the same file, like the command line, sees the
+	// token and its revocation.
+	other, err := NewTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := other.Authenticate(secret); !ok {
+		t.Error("token is not visible to a second store")
+	}
+	if _, err := other.Revoke(token.ID); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	// Make sure the modification time differs from the last load.
+	future := time.Now().Add(time.Second)
+	os.Chtimes(path, future, future)
+	if _, ok := s.Authenticate(secret); ok {
+		t.Error("revoked token still authenticates")
+	}
+	if _, ok := s.Get(token.ID); ok {
+		t.Error("Get returned a revoked token")
+	}
+	if _, err := s.Revoke("missing"); err != ErrTokenNotFound {
+		t.Errorf("Revoke(missing) = %v, want ErrTokenNotFound", err)
+	}
+
+	tokens, err := s.List()
+	if err != nil || len(tokens) != 1 || tokens[0].RevokedAt == nil || tokens[0].Hash != "" {
+		t.Errorf("List = %+v, %v", tokens, err)
+	}
+}
+
+func TestTokenStoreExpiry(t *testing.T) {
+	s, err := NewTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, secret, err := s.Create("short", RoleViewer, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if token.ExpiresAt == nil {
+		t.Fatal("token with a lifetime has no expiry")
+	}
+	if _, ok := s.Authenticate(secret); !ok {
+		t.Fatal("token does not authenticate before it expires")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := s.Authenticate(secret); ok {
+		t.Error("expired token still authenticates")
+	}
+	if _, ok := s.Get(token.ID); ok {
+		t.Error("Get returned an expired token")
+	}
+}
+
+func TestTokenStoreCreateValidates(t *testing.T) {
+	s, err := NewTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Create(" ", RoleViewer, 0); err == nil {
+		t.Error("Create accepted an empty name")
+	}
+	if _, _, err := s.Create("x", Role("root"), 0); err == nil {
+		t.Error("Create accepted an unknown role")
+	}
+	if _, _, err := s.Create("x", RoleViewer, -time.Second); err == nil {
+		t.Error("Create accepted a negative lifetime")
+	}
+}
+
+func TestSecretEqual(t *testing.T) {
+	if !SecretEqual("secret", "secret") {
+		t.Error("equal secrets differ")
+	}
+	for _, other := range []string{"", "secreT", "secret ", "secre"} {
+		if SecretEqual("secret", other) {
+			t.Errorf("SecretEqual(secret, %q) = true", other)
+		}
+	}
+}
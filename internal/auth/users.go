@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the shortest password a user may set.
+const MinPasswordLength = 8
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrUserExists   = errors.New("user already exists")
+
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+	// dummyHash is compared against when a login names an unknown user, so
+	// the response time does not reveal which usernames exist. It is built on
+	// first use to keep bcrypt off the startup path of every command.
+	dummyHash = sync.OnceValue(func() []byte {
+		hash, _ := bcrypt.GenerateFromPassword([]byte("wangshu-manager"), bcrypt.DefaultCost)
+		return hash
+	})
+)
+
+// User is a local account. Passwords are stored as bcrypt hashes.
+type User struct {
+	Username     string    `json:"username"`
+	Role         Role      `json:"role"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// UserStore keeps user accounts in a JSON file that only the manager reads.
+// Like TokenStore it picks up changes made with the command line.
+type UserStore struct {
+	file  jsonFile
+	mu    sync.Mutex
+	users map[string]*User
+}
+
+type userFile struct {
+	Users []*User `json:"users"`
+}
+
+func NewUserStore(path string) (*UserStore, error) {
+	s := &UserStore{file: jsonFile{path: path}, users: make(map[string]*User)}
+	if err := s.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *UserStore) reloadLocked() error {
+	var f userFile
+	changed, err := s.file.reload(&f)
+	if changed {
+		s.users = make(map[string]*User, len(f.Users))
+		for _, u := range f.Users {
+			s.users[u.Username] = u
+		}
+	}
+	return err
+}
+
+func (s *UserStore) saveLocked() error {
+	f := userFile{Users: make([]*User, 0, len(s.users))}
+	for _, name := range sortedUsernames(s.users) {
+		f.Users = append(f.Users, s.users[name])
+	}
+	return s.file.save(f)
+}
+
+// Create adds a user with the given password and role.
+func (s *UserStore) Create(username, password string, role Role) (User, error) {
+	if !usernamePattern.MatchString(username) {
+		return User{}, fmt.Errorf("invalid username %q: use up to 64 letters, digits, '.', '_' or '-'", username)
+	}
+	if _, err := ParseRole(string(role)); err != nil {
+		return User{}, err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reloadLocked(); err != nil {
+		return User{}, err
+	}
+	if _, exists := s.users[username]; exists {
+		return User{}, ErrUserExists
+	}
+
+	now := time.Now().UTC()
+	u := &User{Username: username, Role: role, PasswordHash: hash, CreatedAt: now, UpdatedAt: now}
+	s.users[username] = u
+	if err := s.saveLocked(); err != nil {
+		delete(s.users, username)
+		return User{}, err
+	}
+	return u.public(), nil
+}
+
+// Update changes the role and, if password is not empty, the password of a
+// user. An empty role keeps the current one.
+func (s *UserStore) Update(username string, role Role, password string) (User, error) {
+	if role != "" {
+		if _, err := ParseRole(string(role)); err != nil {
+			return User{}, err
+		}
+	}
+	var hash string
+	if password != "" {
+		var err error
+		if hash, err = hashPassword(password); err != nil {
+			return User{}, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reloadLocked(); err != nil {
+		return User{}, err
+	}
+	u, ok := s.users[username]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+
+	prev := *u
+	if role != "" {
+		u.Role = role
+	}
+	if hash != "" {
+		u.PasswordHash = hash
+	}
+	u.UpdatedAt = time.Now().UTC()
+	if err := s.saveLocked(); err != nil {
+		*u = prev
+		return User{}, err
+	}
+	return u.public(), nil
+}
+
+func (s *UserStore) Delete(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reloadLocked(); err != nil {
+		return err
+	}
+	u, ok := s.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	delete(s.users, username)
+	if err := s.saveLocked(); err != nil {
+		s.users[username] = u
+		return err
+	}
+	return nil
+}
+
+// List returns every user without password hashes, sorted by name.
+func (s *UserStore) List() ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reloadLocked(); err != nil {
+		return nil, err
+	}
+	users := make([]User, 0, len(s.users))
+	for _, name := range sortedUsernames(s.users) {
+		users = append(users, s.users[name].public())
+	}
+	return users, nil
+}
+
+// Get returns a user including the password hash, which callers use to tell
+// whether the password changed.
+func (s *UserStore) Get(username string) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reloadLocked(); err != nil {
+		slog.Error("Failed to reload users", "error", err)
+	}
+	u, ok := s.users[username]
+	if !ok {
+		return User{}, false
+	}
+	return *u, true
+}
+
+// Authenticate checks a username and password and returns the user,
+// including the password hash, when they match.
+func (s *UserStore) Authenticate(username, password string) (User, bool) {
+	u, ok := s.Get(username)
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return User{}, false
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)); err != nil {
+		return User{}, false
+	}
+	return u, true
+}
+
+func (u *User) public() User {
+	cp := *u
+	cp.PasswordHash = ""
+	return cp
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+func sortedUsernames(users map[string]*User) []string {
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
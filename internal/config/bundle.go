@@ -0,0 +1,458 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+const (
+	BundleVersion = 1
+
+	ImportMerge   = "merge"
+	ImportReplace = "replace"
+
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+
+	maxBundleSkillsSize = 10 << 20
+	pbkdf2Iterations    = 600000
+)
+
+var ErrBadPassphrase = errors.New("wrong passphrase or corrupted secrets")
+
+// Bundle is a portable snapshot of agents, providers and channels. Secrets are
+// either stripped or stored encrypted in Secrets, never in the entries.
+type Bundle struct {
+	Version   int                       `json:"version"`
+	CreatedAt time.Time                 `json:"created_at"`
+	Agents    map[string]AgentConfig    `json:"agents"`
+	Providers map[string]ProviderConfig `json:"providers"`
+	Channels  map[string]ChannelConfig  `json:"channels"`
+	// StrippedSecrets lists the secret fields removed from the entries, e.g.
+	// "providers.myProvider.api_key".
+	StrippedSecrets []string          `json:"stripped_secrets"`
+	Secrets         *EncryptedSecrets `json:"secrets,omitempty"`
+	Skills          []BundleFile      `json:"skills,omitempty"`
+}
+
+type EncryptedSecrets struct {
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type BundleFile struct {
+	Path    string `json:"path"`
+	Content []byte `json:"content"`
+}
+
+type ImportOptions struct {
+	Mode       string
+	OnConflict string
+	Passphrase string
+	Catalog    ModelCatalog
+}
+
+type ImportConflict struct {
+	Path       string `json:"path"`
+	Resolution string `json:"resolution"`
+}
+
+type ImportReport struct {
+	Mode           string            `json:"mode"`
+	Conflicts      []ImportConflict  `json:"conflicts"`
+	MissingSecrets []string          `json:"missing_secrets"`
+	Skills         []string          `json:"skills"`
+	Diff           *ConfigDiff       `json:"diff"`
+	Validation     *ValidationResult `json:"validation"`
+}
+
+// Clone returns a copy of c whose maps can be modified independently.
+func (c *Config) Clone() *Config {
+	return &Config{
+		Agents:    cloneMap(c.Agents),
+		Providers: cloneMap(c.Providers),
+		Channels:  cloneMap(c.Channels),
+		Skill:     c.Skill,
+	}
+}
+
+// ExportBundle builds a bundle from cfg. With an empty passphrase secrets are
+// dropped, otherwise they are encrypted with a key derived from it. Skills
+// from the global skill path are included when withSkills is set.
+func ExportBundle(cfg *Config, passphrase string, withSkills bool) (*Bundle, error) {
+	b := &Bundle{
+		Version:   BundleVersion,
+		CreatedAt: time.Now().UTC(),
+		Agents:    cloneMap(cfg.Agents),
+		Providers: cloneMap(cfg.Providers),
+		Channels:  cloneMap(cfg.Channels),
+	}
+
+	secrets := make(map[string]string)
+	stripSecrets(b.Agents, "agents", secrets)
+	stripSecrets(b.Providers, "providers", secrets)
+	stripSecrets(b.Channels, "channels", secrets)
+
+	b.StrippedSecrets = sortedKeys(secrets)
+
+	if passphrase != "" && len(secrets) > 0 {
+		enc, err := encryptSecrets(secrets, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		b.Secrets = enc
+	}
+
+	if withSkills && cfg.Skill.GlobalPath != "" {
+		files, err := readSkills(ExpandPath(cfg.Skill.GlobalPath))
+		if err != nil {
+			return nil, err
+		}
+		b.Skills = files
+	}
+
+	return b, nil
+}
+
+// ImportBundle applies b on top of live and returns the resulting config
+// together with a report. live is not modified.
+func ImportBundle(live *Config, b *Bundle, opts ImportOptions) (*Config, *ImportReport, error) {
+	if b.Version != BundleVersion {
+		return nil, nil, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	if opts.Mode == "" {
+		opts.Mode = ImportMerge
+	}
+	if opts.OnConflict == "" {
+		opts.OnConflict = ConflictSkip
+	}
+	if opts.Mode != ImportMerge && opts.Mode != ImportReplace {
+		return nil, nil, fmt.Errorf("unsupported import mode %q", opts.Mode)
+	}
+	if opts.OnConflict != ConflictSkip && opts.OnConflict != ConflictOverwrite {
+		return nil, nil, fmt.Errorf("unsupported conflict resolution %q", opts.OnConflict)
+	}
+
+	incoming := &Config{
+		Agents:    cloneMap(b.Agents),
+		Providers: cloneMap(b.Providers),
+		Channels:  cloneMap(b.Channels),
+	}
+	if b.Secrets != nil {
+		if opts.Passphrase == "" {
+			return nil, nil, fmt.Errorf("bundle secrets are encrypted, a passphrase is required")
+		}
+		secrets, err := decryptSecrets(b.Secrets, opts.Passphrase)
+		if err != nil {
+			return nil, nil, err
+		}
+		restoreSecrets(incoming.Agents, "agents", secrets)
+		restoreSecrets(incoming.Providers, "providers", secrets)
+		restoreSecrets(incoming.Channels, "channels", secrets)
+	}
+
+	report := &ImportReport{
+		Mode:           opts.Mode,
+		Conflicts:      []ImportConflict{},
+		MissingSecrets: []string{},
+		Skills:         []string{},
+	}
+
+	result := live.Clone()
+	if opts.Mode == ImportReplace {
+		result.Agents = make(map[string]AgentConfig)
+		result.Providers = make(map[string]ProviderConfig)
+		result.Channels = make(map[string]ChannelConfig)
+	}
+	stripped := make(map[string]bool, len(b.StrippedSecrets))
+	for _, p := range b.StrippedSecrets {
+		stripped[p] = true
+	}
+	result.Agents = mergeEntries(result.Agents, incoming.Agents, live.Agents, "agents", opts.OnConflict, stripped, report)
+	result.Providers = mergeEntries(result.Providers, incoming.Providers, live.Providers, "providers", opts.OnConflict, stripped, report)
+	result.Channels = mergeEntries(result.Channels, incoming.Channels, live.Channels, "channels", opts.OnConflict, stripped, report)
+
+	for _, f := range b.Skills {
+		if !filepath.IsLocal(filepath.FromSlash(f.Path)) {
+			return nil, nil, fmt.Errorf("invalid skill file path %q", f.Path)
+		}
+		report.Skills = append(report.Skills, f.Path)
+	}
+
+	report.Diff = Diff(live, result)
+	report.Validation = ValidateWithCatalog(result, opts.Catalog)
+	return result, report, nil
+}
+
+// WriteBundleSkills stores the skill files of b under the global skill path
+// of cfg. Existing files are only replaced when overwrite is set. The
+// returned function puts back the files as they were before, so an import
+// that fails later can be undone; when writing fails this is already done.
+func WriteBundleSkills(cfg *Config, b *Bundle, overwrite bool) (undo func(), err error) {
+	if len(b.Skills) == 0 {
+		return func() {}, nil
+	}
+	if cfg.Skill.GlobalPath == "" {
+		return nil, fmt.Errorf("skill.global_path is not configured")
+	}
+	root := ExpandPath(cfg.Skill.GlobalPath)
+
+	var restore skillRestore
+	defer func() {
+		if err != nil {
+			restore.undo()
+		}
+	}()
+	for _, f := range b.Skills {
+		rel := filepath.FromSlash(f.Path)
+		if !filepath.IsLocal(rel) {
+			return nil, fmt.Errorf("invalid skill file path %q", f.Path)
+		}
+		target := filepath.Join(root, rel)
+		previous, err := os.ReadFile(target)
+		if err == nil && !overwrite {
+			continue
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read skill file: %w", err)
+		}
+		if err := restore.mkdirAll(filepath.Dir(target)); err != nil {
+			return nil, fmt.Errorf("failed to create skill directory: %w", err)
+		}
+		restore.files = append(restore.files, skillFile{path: target, previous: previous, existed: err == nil})
+		if err := os.WriteFile(target, f.Content, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write skill file: %w", err)
+		}
+	}
+	return restore.undo, nil
+}
+
+// skillRestore remembers what WriteBundleSkills changed on disk.
+type skillRestore struct {
+	files []skillFile
+	// dirs lists the directories that were created, parents first.
+	dirs []string
+}
+
+type skillFile struct {
+	path     string
+	previous []byte
+	existed  bool
+}
+
+func (r *skillRestore) mkdirAll(dir string) error {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || filepath.Dir(d) == d {
+			break
+		}
+		missing = append(missing, d)
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+		r.dirs = append(r.dirs, missing[i])
+	}
+	return nil
+}
+
+func (r *skillRestore) undo() {
+	for i := len(r.files) - 1; i >= 0; i-- {
+		f := r.files[i]
+		if f.existed {
+			os.WriteFile(f.path, f.previous, 0644)
+		} else {
+			os.Remove(f.path)
+		}
+	}
+	for i := len(r.dirs) - 1; i >= 0; i-- {
+		os.Remove(r.dirs[i])
+	}
+}
+
+// mergeEntries adds incoming to current. Secrets the bundle lacks are taken
+// from the live entry of the same name, so importing a teammate's setup does
+// not wipe local keys.
+func mergeEntries[V any](current, incoming, live map[string]V, section, onConflict string, stripped map[string]bool, report *ImportReport) map[string]V {
+	for _, name := range sortedKeys(incoming) {
+		value := incoming[name]
+		p := section + "." + name
+		if liveValue, ok := live[name]; ok {
+			value = keepSecrets(value, liveValue)
+		}
+		existing, ok := current[name]
+		if ok && !reflect.DeepEqual(existing, value) {
+			report.Conflicts = append(report.Conflicts, ImportConflict{Path: p, Resolution: onConflict})
+			if onConflict == ConflictSkip {
+				continue
+			}
+		}
+		for _, field := range emptySecrets(value, p) {
+			if stripped[field] {
+				report.MissingSecrets = append(report.MissingSecrets, field)
+			}
+		}
+		current[name] = value
+	}
+	return current
+}
+
+func keepSecrets[V any](incoming, existing V) V {
+	existingSecrets := make(map[string]string)
+	for _, f := range secretFields(reflect.ValueOf(existing)) {
+		existingSecrets[f.name] = f.get()
+	}
+	for _, f := range secretFields(reflect.ValueOf(&incoming).Elem()) {
+		if secret := existingSecrets[f.name]; f.get() == "" && secret != "" {
+			f.set(secret)
+		}
+	}
+	return incoming
+}
+
+func emptySecrets[V any](v V, prefix string) []string {
+	var missing []string
+	for _, f := range secretFields(reflect.ValueOf(&v).Elem()) {
+		if f.get() == "" {
+			missing = append(missing, prefix+"."+f.name)
+		}
+	}
+	return missing
+}
+
+func stripSecrets[V any](entries map[string]V, section string, secrets map[string]string) {
+	for name, entry := range entries {
+		for _, f := range secretFields(reflect.ValueOf(&entry).Elem()) {
+			if secret := f.get(); secret != "" {
+				secrets[section+"."+name+"."+f.name] = secret
+				f.set("")
+			}
+		}
+		entries[name] = entry
+	}
+}
+
+func restoreSecrets[V any](entries map[string]V, section string, secrets map[string]string) {
+	for name, entry := range entries {
+		for _, f := range secretFields(reflect.ValueOf(&entry).Elem()) {
+			if secret, ok := secrets[section+"."+name+"."+f.name]; ok {
+				f.set(secret)
+			}
+		}
+		entries[name] = entry
+	}
+}
+
+func encryptSecrets(secrets map[string]string, passphrase string) (*EncryptedSecrets, error) {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := secretsCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return &EncryptedSecrets{
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, nil),
+	}, nil
+}
+
+func decryptSecrets(enc *EncryptedSecrets, passphrase string) (map[string]string, error) {
+	gcm, err := secretsCipher(passphrase, enc.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(enc.Nonce) != gcm.NonceSize() {
+		return nil, ErrBadPassphrase
+	}
+	plaintext, err := gcm.Open(nil, enc.Nonce, enc.Ciphertext, nil)
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+
+	var secrets map[string]string
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, ErrBadPassphrase
+	}
+	return secrets, nil
+}
+
+func secretsCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, pbkdf2Iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func readSkills(root string) ([]BundleFile, error) {
+	var files []BundleFile
+	total := 0
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		total += len(data)
+		if total > maxBundleSkillsSize {
+			return fmt.Errorf("skills exceed the bundle size limit of %d bytes", maxBundleSkillsSize)
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, BundleFile{Path: path.Clean(filepath.ToSlash(rel)), Content: data})
+		return nil
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read skills: %w", err)
+	}
+	return files, nil
+}
+
+func cloneMap[V any](m map[string]V) map[string]V {
+	out := make(map[string]V, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,213 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBundleEncryptedSecretsRoundTrip(t *testing.T) {
+	b, err := ExportBundle(diffTestConfig(), "passphrase", false)
+	if err != nil {
+		t.Fatalf("ExportBundle: %v", err)
+	}
+	if b.Providers["p"].APIKey != "" {
+		t.Errorf("exported provider kept its api_key")
+	}
+	if web, _ := b.Channels["web"].Web(); web.Token != "" {
+		t.Errorf("exported web channel kept its token")
+	}
+	if b.Secrets == nil {
+		t.Fatal("exported bundle has no encrypted secrets")
+	}
+
+	empty := &Config{}
+	if _, _, err := ImportBundle(empty, b, ImportOptions{}); err == nil {
+		t.Error("import without passphrase succeeded")
+	}
+	if _, _, err := ImportBundle(empty, b, ImportOptions{Passphrase: "wrong"}); !errors.Is(err, ErrBadPassphrase) {
+		t.Errorf("import with wrong passphrase: err = %v, want ErrBadPassphrase", err)
+	}
+
+	cfg, report, err := ImportBundle(empty, b, ImportOptions{Passphrase: "passphrase"})
+	if err != nil {
+		t.Fatalf("ImportBundle: %v", err)
+	}
+	if cfg.Providers["p"].APIKey != "key" {
+		t.Errorf("imported api_key = %q, want %q", cfg.Providers["p"].APIKey, "key")
+	}
+	if web, _ := cfg.Channels["web"].Web(); web.Token != "tok" {
+		t.Errorf("imported token = %q, want %q", web.Token, "tok")
+	}
+	if len(report.MissingSecrets) != 0 {
+		t.Errorf("missing secrets = %v, want none", report.MissingSecrets)
+	}
+}
+
+func TestBundleWithoutPassphraseReportsMissingSecrets(t *testing.T) {
+	b, err := ExportBundle(diffTestConfig(), "", false)
+	if err != nil {
+		t.Fatalf("ExportBundle: %v", err)
+	}
+	if b.Secrets != nil {
+		t.Error("bundle exported without passphrase carries secrets")
+	}
+
+	_, report, err := ImportBundle(&Config{}, b, ImportOptions{})
+	if err != nil {
+		t.Fatalf("ImportBundle: %v", err)
+	}
+	want := []string{"providers.p.api_key", "channels.web.token"}
+	if len(report.MissingSecrets) != len(want) {
+		t.Fatalf("missing secrets = %v, want %v", report.MissingSecrets, want)
+	}
+	for i := range want {
+		if report.MissingSecrets[i] != want[i] {
+			t.Errorf("missing secrets = %v, want %v", report.MissingSecrets, want)
+		}
+	}
+}
+
+func TestImportBundleMerge(t *testing.T) {
+	live := diffTestConfig()
+	b := &Bundle{
+		Version: BundleVersion,
+		Agents: map[string]AgentConfig{
+			"default": {Workspace: "ws", Provider: "p", Model: "imported"},
+			"new":     {Workspace: "ws2", Provider: "p", Model: "m"},
+		},
+		// The stripped api_key is kept from the live provider.
+		Providers:       map[string]ProviderConfig{"p": {Type: "openai"}},
+		StrippedSecrets: []string{"providers.p.api_key"},
+	}
+
+	tests := []struct {
+		name       string
+		opts       ImportOptions
+		model      string
+		channels   int
+		conflicts  int
+		apiKeyKept bool
+	}{
+		{"merge skip", ImportOptions{}, "m", 1, 1, true},
+		{"merge overwrite", ImportOptions{OnConflict: ConflictOverwrite}, "imported", 1, 1, true},
+		{"replace", ImportOptions{Mode: ImportReplace}, "imported", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, report, err := ImportBundle(live, b, tt.opts)
+			if err != nil {
+				t.Fatalf("ImportBundle: %v", err)
+			}
+			if got := cfg.Agents["default"].Model; got != tt.model {
+				t.Errorf("default agent model = %q, want %q", got, tt.model)
+			}
+			if _, ok := cfg.Agents["new"]; !ok {
+				t.Error("new agent was not imported")
+			}
+			if len(cfg.Channels) != tt.channels {
+				t.Errorf("channels = %d, want %d", len(cfg.Channels), tt.channels)
+			}
+			if len(report.Conflicts) != tt.conflicts {
+				t.Errorf("conflicts = %v, want %d", report.Conflicts, tt.conflicts)
+			}
+			if (cfg.Providers["p"].APIKey == "key") != tt.apiKeyKept {
+				t.Errorf("api_key = %q", cfg.Providers["p"].APIKey)
+			}
+			if len(report.MissingSecrets) != 0 {
+				t.Errorf("missing secrets = %v, want none", report.MissingSecrets)
+			}
+		})
+	}
+	if live.Agents["default"].Model != "m" || len(live.Agents) != 1 {
+		t.Errorf("ImportBundle modified the live config: %+v", live.Agents)
+	}
+}
+
+func TestWriteBundleSkillsUndo(t *testing.T) {
+	root := t.TempDir()
+	existing := filepath.Join(root, "a", "SKILL.md")
+	if err := os.MkdirAll(filepath.Dir(existing), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(existing, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{Skill: SkillConfig{GlobalPath: root}}
+	b := &Bundle{Skills: []BundleFile{
+		{Path: "a/SKILL.md", Content: []byte("new")},
+		{Path: "b/c/SKILL.md", Content: []byte("added")},
+	}}
+	undo, err := WriteBundleSkills(cfg, b, true)
+	if err != nil {
+		t.Fatalf("WriteBundleSkills: %v", err)
+	}
+	if data, _ := os.ReadFile(existing); string(data) != "new" {
+		t.Errorf("existing skill = %q, want %q", data, "new")
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "b", "c", "SKILL.md")); string(data) != "added" {
+		t.Errorf("added skill = %q, want %q", data, "added")
+	}
+
+	undo()
+	if data, _ := os.ReadFile(existing); string(data) != "old" {
+		t.Errorf("existing skill after undo = %q, want %q", data, "old")
+	}
+	if _, err := os.Stat(filepath.Join(root, "b")); !os.IsNotExist(err) {
+		t.Errorf("directory of added skill survived undo: %v", err)
+	}
+}
+
+func TestBundleStripsSecretsOfUnknownChannelTypes(t *testing.T) {
+	var ch ChannelConfig
+	if err := json.Unmarshal([]byte(`{"type":"telegram","enabled":true,"agent":"default","bot_token":"123:abc","chat_id":"42"}`), &ch); err != nil {
+		t.Fatal(err)
+	}
+	live := diffTestConfig()
+	live.Channels["tg"] = ch
+
+	b, err := ExportBundle(live, "", false)
+	if err != nil {
+		t.Fatalf("ExportBundle: %v", err)
+	}
+	data, err := json.Marshal(b.Channels["tg"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "123:abc") {
+		t.Errorf("exported channel kept its bot_token: %s", data)
+	}
+	if !strings.Contains(string(data), `"chat_id":"42"`) {
+		t.Errorf("exported channel lost chat_id: %s", data)
+	}
+	if !slices.Contains(b.StrippedSecrets, "channels.tg.bot_token") {
+		t.Errorf("stripped secrets = %v, want channels.tg.bot_token", b.StrippedSecrets)
+	}
+	if original, _ := json.Marshal(live.Channels["tg"]); !strings.Contains(string(original), "123:abc") {
+		t.Errorf("ExportBundle modified the live channel: %s", original)
+	}
+
+	encrypted, err := ExportBundle(live, "passphrase", false)
+	if err != nil {
+		t.Fatalf("ExportBundle: %v", err)
+	}
+	cfg, _, err := ImportBundle(&Config{}, encrypted, ImportOptions{Passphrase: "passphrase"})
+	if err != nil {
+		t.Fatalf("ImportBundle: %v", err)
+	}
+	if imported, _ := json.Marshal(cfg.Channels["tg"]); !strings.Contains(string(imported), `"bot_token":"123:abc"`) {
+		t.Errorf("imported channel lost its bot_token: %s", imported)
+	}
+
+	d := Diff(&Config{}, live)
+	for _, change := range d.Channels {
+		if data, _ := json.Marshal(change); strings.Contains(string(data), "123:abc") {
+			t.Errorf("diff shows the bot_token: %s", data)
+		}
+	}
+}
@@ -0,0 +1,593 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	ChannelTypeWeb    = "web"
+	ChannelTypeFeishu = "feishu"
+)
+
+// ChannelSettings is the type specific part of a channel. Implementations
+// are pointers to structs whose fields use the same tags as the rest of the
+// config: `json` for the name, `secret:"true"` for credentials,
+// `schema:"required"` for fields an enabled channel must set, `default` for
+// the value a missing field takes, and `desc` for a human readable hint.
+type ChannelSettings interface {
+	// Validate checks the settings beyond what the schema expresses. Issue
+	// paths are relative to the channel.
+	Validate(enabled bool) []ValidationIssue
+}
+
+// ChannelType describes a kind of channel. Register new kinds from an init
+// function with RegisterChannelType; the config schema is built from the
+// registry on first use.
+type ChannelType struct {
+	Name     string
+	Title    string
+	Settings func() ChannelSettings
+}
+
+type ChannelField struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Required    bool        `json:"required"`
+	Secret      bool        `json:"secret"`
+	Default     interface{} `json:"default,omitempty"`
+	Description string      `json:"description,omitempty"`
+}
+
+// ChannelTypeInfo is the description of a channel type served to clients so
+// they can render settings forms for types they do not know.
+type ChannelTypeInfo struct {
+	Name   string         `json:"name"`
+	Title  string         `json:"title"`
+	Fields []ChannelField `json:"fields"`
+}
+
+var (
+	channelTypesMu sync.RWMutex
+	channelTypes   = make(map[string]ChannelType)
+
+	hostNamePattern = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)*$`)
+)
+
+func RegisterChannelType(t ChannelType) {
+	channelTypesMu.Lock()
+	defer channelTypesMu.Unlock()
+
+	if _, exists := channelTypes[t.Name]; exists {
+		panic(fmt.Sprintf("config: channel type %q registered twice", t.Name))
+	}
+	channelTypes[t.Name] = t
+}
+
+func LookupChannelType(name string) (ChannelType, bool) {
+	channelTypesMu.RLock()
+	defer channelTypesMu.RUnlock()
+
+	t, ok := channelTypes[name]
+	return t, ok
+}
+
+func ChannelTypeNames() []string {
+	channelTypesMu.RLock()
+	defer channelTypesMu.RUnlock()
+
+	return sortedKeys(channelTypes)
+}
+
+// NewChannelSettings returns the settings of a new channel of the given type
+// with its declared defaults applied. Unregistered types get a
+// RawChannelSettings so their fields survive a load/save round trip.
+func NewChannelSettings(typeName string) ChannelSettings {
+	t, ok := LookupChannelType(typeName)
+	if !ok {
+		return &RawChannelSettings{}
+	}
+	settings := t.Settings()
+	applyDefaults(reflect.ValueOf(settings).Elem())
+	return settings
+}
+
+// DescribeChannelTypes lists every registered channel type with its fields.
+func DescribeChannelTypes() []ChannelTypeInfo {
+	var infos []ChannelTypeInfo
+	for _, name := range ChannelTypeNames() {
+		t, _ := LookupChannelType(name)
+		st := reflect.TypeOf(t.Settings()).Elem()
+		info := ChannelTypeInfo{Name: t.Name, Title: t.Title, Fields: []ChannelField{}}
+		for i := 0; i < st.NumField(); i++ {
+			f := st.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			field := ChannelField{
+				Name:        jsonFieldName(f),
+				Type:        schemaFor(f.Type).Type,
+				Required:    f.Tag.Get("schema") == "required",
+				Secret:      isSecretField(f),
+				Description: f.Tag.Get("desc"),
+			}
+			if def, ok := f.Tag.Lookup("default"); ok {
+				field.Default = parseDefault(f.Type, def)
+			}
+			info.Fields = append(info.Fields, field)
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func applyDefaults(rv reflect.Value) {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		def, ok := t.Field(i).Tag.Lookup("default")
+		if !ok {
+			continue
+		}
+		if v := parseDefault(t.Field(i).Type, def); v != nil {
+			rv.Field(i).Set(reflect.ValueOf(v).Convert(t.Field(i).Type))
+		}
+	}
+}
+
+func parseDefault(t reflect.Type, def string) interface{} {
+	switch t.Kind() {
+	case reflect.String:
+		return def
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(def); err == nil {
+			return b
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, err := strconv.ParseInt(def, 10, 64); err == nil {
+			return n
+		}
+	case reflect.Float32, reflect.Float64:
+		if n, err := strconv.ParseFloat(def, 64); err == nil {
+			return n
+		}
+	}
+	return nil
+}
+
+// MarshalJSON writes the settings next to the common channel fields, keeping
+// the flat layout the wangshu config file has always used.
+func (c ChannelConfig) MarshalJSON() ([]byte, error) {
+	head, err := json.Marshal(channelBase{Type: c.Type, Enabled: c.Enabled, Agent: c.Agent})
+	if err != nil {
+		return nil, err
+	}
+	if c.Settings == nil {
+		return head, nil
+	}
+	body, err := json.Marshal(c.Settings)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) <= 2 || body[0] != '{' {
+		return head, nil
+	}
+	out := make([]byte, 0, len(head)+len(body))
+	out = append(out, head[:len(head)-1]...)
+	out = append(out, ',')
+	return append(out, body[1:]...), nil
+}
+
+func (c *ChannelConfig) UnmarshalJSON(data []byte) error {
+	var base channelBase
+	if err := json.Unmarshal(data, &base); err != nil {
+		return err
+	}
+	settings := NewChannelSettings(base.Type)
+	if err := json.Unmarshal(data, settings); err != nil {
+		return err
+	}
+	if raw, ok := settings.(*RawChannelSettings); ok {
+		delete(*raw, "type")
+		delete(*raw, "enabled")
+		delete(*raw, "agent")
+	}
+
+	c.Type = base.Type
+	c.Enabled = base.Enabled
+	c.Agent = base.Agent
+	c.Settings = settings
+	return nil
+}
+
+type channelBase struct {
+	Type    string `json:"type"`
+	Enabled bool   `json:"enabled"`
+	Agent   string `json:"agent"`
+}
+
+// Web returns the settings of a web channel. The returned value is shared
+// with the config and must not be modified.
+func (c ChannelConfig) Web() (*WebSettings, bool) {
+	s, ok := c.Settings.(*WebSettings)
+	return s, ok && c.Type == ChannelTypeWeb
+}
+
+// Feishu returns the settings of a feishu channel. The returned value is
+// shared with the config and must not be modified.
+func (c ChannelConfig) Feishu() (*FeishuSettings, bool) {
+	s, ok := c.Settings.(*FeishuSettings)
+	return s, ok && c.Type == ChannelTypeFeishu
+}
+
+// RawChannelSettings keeps the fields of a channel whose type is not
+// registered.
+type RawChannelSettings map[string]json.RawMessage
+
+func (s *RawChannelSettings) Validate(enabled bool) []ValidationIssue {
+	return nil
+}
+
+type WebSettings struct {
+	HostAddress string `json:"host_address,omitempty" default:"localhost:8080" desc:"Address the manager listens on, e.g. localhost:8080"`
+	Token       string `json:"token,omitempty" secret:"true" desc:"Token clients must present"`
+	NoAuth      bool   `json:"no_auth,omitempty" desc:"Accept requests without a token"`
+	// AllowRemote permits addresses other than loopback ones, which includes
+	// binding every interface with ":8080" or "0.0.0.0:8080".
+	AllowRemote bool     `json:"allow_remote,omitempty" desc:"Listen on a non-loopback address; requires TLS and a token"`
+	AllowedIPs  []string `json:"allowed_ips,omitempty" desc:"Client IPs or CIDR ranges that may connect, e.g. 192.168.1.0/24; empty allows all"`
+	// SocketMode and SocketRoles only apply to unix:/path addresses.
+	SocketMode  string   `json:"socket_mode,omitempty" default:"0600" desc:"Permissions of the unix socket file, in octal"`
+	SocketRoles []string `json:"socket_roles,omitempty" desc:"Roles of local users connecting over the unix socket, as uid:role, e.g. 1000:admin"`
+	// AllowedOrigins lists origins, besides the listener's own, whose pages
+	// may call the API and open WebSockets.
+	AllowedOrigins []string `json:"allowed_origins,omitempty" desc:"Other origins allowed to call the API, e.g. https://admin.example.com"`
+	// AllowedHosts lists host names, besides the address and loopback
+	// names, that clients may reach the listener by, such as the names its
+	// certificate is issued for. Requests for other hosts are rejected.
+	AllowedHosts []string `json:"allowed_hosts,omitempty" desc:"Other host names clients reach the listener by, e.g. manager.example.com"`
+	// TLSCert and TLSKey are read again whenever the files change, so
+	// rotated certificates are picked up without a restart.
+	TLSCert       string `json:"tls_cert,omitempty" desc:"Path of the PEM certificate to serve HTTPS and wss:// with"`
+	TLSKey        string `json:"tls_key,omitempty" desc:"Path of the PEM private key of tls_cert"`
+	TLSSelfSigned bool   `json:"tls_self_signed,omitempty" desc:"Serve HTTPS with a generated self-signed certificate"`
+}
+
+// TLSEnabled reports whether the listener serves HTTPS.
+func (s *WebSettings) TLSEnabled() bool {
+	return s.TLSSelfSigned || s.TLSCert != ""
+}
+
+// Address returns the listen address, falling back to localhost:8080.
+func (s *WebSettings) Address() string {
+	if s.HostAddress == "" {
+		return "localhost:8080"
+	}
+	return s.HostAddress
+}
+
+// UnixSocketPath returns the socket path of a unix:/path address.
+func UnixSocketPath(addr string) (string, bool) {
+	return strings.CutPrefix(addr, "unix:")
+}
+
+// SocketFileMode returns the permissions of the unix socket file.
+func (s *WebSettings) SocketFileMode() (os.FileMode, error) {
+	if s.SocketMode == "" {
+		return 0600, nil
+	}
+	mode, err := strconv.ParseUint(s.SocketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid socket_mode %q: use octal permissions such as 0660", s.SocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// SocketRoleNames are the roles socket_roles may grant. They are the roles
+// of the auth package, which turns them into permissions.
+var SocketRoleNames = []string{"viewer", "operator", "admin"}
+
+// SocketRoleMap parses socket_roles into role names by uid.
+func (s *WebSettings) SocketRoleMap() (map[int]string, error) {
+	roles := make(map[int]string, len(s.SocketRoles))
+	for _, entry := range s.SocketRoles {
+		uid, role, ok := strings.Cut(entry, ":")
+		id, err := strconv.Atoi(uid)
+		if !ok || err != nil || id < 0 {
+			return nil, fmt.Errorf("invalid socket_roles entry %q: use uid:role, e.g. 1000:admin", entry)
+		}
+		if !slices.Contains(SocketRoleNames, role) {
+			return nil, fmt.Errorf("invalid socket_roles entry %q: unknown role %q, expected viewer, operator or admin", entry, role)
+		}
+		roles[id] = role
+	}
+	return roles, nil
+}
+
+// PeerAuth reports whether clients are authenticated by the uid they
+// connect to the unix socket with.
+func (s *WebSettings) PeerAuth() bool {
+	_, unix := UnixSocketPath(s.Address())
+	return unix && len(s.SocketRoles) > 0
+}
+
+// CheckRemote returns an error if the listen address is reachable from
+// other hosts without the safeguards that requires: allow_remote, TLS and
+// a token.
+func (s *WebSettings) CheckRemote() error {
+	if IsLoopbackAddress(s.Address()) {
+		return nil
+	}
+	switch {
+	case !s.AllowRemote:
+		return fmt.Errorf("%s is not a loopback address, set allow_remote to listen on it", s.Address())
+	case !s.TLSEnabled():
+		return fmt.Errorf("allow_remote requires TLS, set tls_cert and tls_key or tls_self_signed")
+	case s.Token == "" || s.NoAuth:
+		return fmt.Errorf("allow_remote requires a token and cannot be combined with no_auth")
+	}
+	return nil
+}
+
+// IsLoopbackAddress reports whether addr only accepts connections from this
+// host. An empty host binds every interface and is not a loopback address;
+// unix sockets are local.
+func IsLoopbackAddress(addr string) bool {
+	if _, unix := UnixSocketPath(addr); unix {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
+
+// ParseAllowedIPs parses IP addresses and CIDR ranges into prefixes; a
+// single address becomes a prefix that matches only itself.
+func ParseAllowedIPs(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR range %q: %v", entry, err)
+			}
+			// Client addresses are unmapped before matching, so ranges
+			// written as IPv4-mapped IPv6 are turned into IPv4 ones too.
+			if addr := prefix.Addr(); addr.Is4In6() && prefix.Bits() >= 96 {
+				prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		ip, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address %q: %v", entry, err)
+		}
+		ip = ip.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func (s *WebSettings) Validate(enabled bool) []ValidationIssue {
+	if !enabled {
+		return nil
+	}
+	var issues []ValidationIssue
+	if path, unix := UnixSocketPath(s.Address()); unix {
+		issues = append(issues, s.validateSocket(path)...)
+	} else if _, _, err := net.SplitHostPort(s.Address()); err != nil {
+		issues = append(issues, ValidationIssue{
+			Severity: SeverityError,
+			Path:     "host_address",
+			Message:  fmt.Sprintf("invalid host_address %q: %v", s.HostAddress, err),
+		})
+	} else if err := s.CheckRemote(); err != nil {
+		issues = append(issues, ValidationIssue{
+			Severity: SeverityError,
+			Path:     "host_address",
+			Message:  err.Error(),
+		})
+	}
+	if _, err := ParseAllowedIPs(s.AllowedIPs); err != nil {
+		issues = append(issues, ValidationIssue{
+			Severity: SeverityError,
+			Path:     "allowed_ips",
+			Message:  err.Error(),
+		})
+	}
+	switch {
+	case s.NoAuth:
+		issues = append(issues, ValidationIssue{
+			Severity: SeverityWarning,
+			Path:     "no_auth",
+			Message:  "no_auth is set, the listener will accept unauthenticated requests",
+		})
+	case s.Token == "" && !s.PeerAuth():
+		issues = append(issues, ValidationIssue{
+			Severity: SeverityError,
+			Path:     "token",
+			Message:  "token is empty, set a token or set no_auth to serve without authentication",
+		})
+	}
+	switch {
+	case (s.TLSCert == "") != (s.TLSKey == ""):
+		issues = append(issues, ValidationIssue{
+			Severity: SeverityError,
+			Path:     "tls_cert",
+			Message:  "tls_cert and tls_key must be set together",
+		})
+	case s.TLSSelfSigned && s.TLSCert != "":
+		issues = append(issues, ValidationIssue{
+			Severity: SeverityError,
+			Path:     "tls_self_signed",
+			Message:  "tls_self_signed cannot be combined with tls_cert and tls_key",
+		})
+	}
+	for _, origin := range s.AllowedOrigins {
+		if origin == "*" {
+			issues = append(issues, ValidationIssue{
+				Severity: SeverityError,
+				Path:     "allowed_origins",
+				Message:  "allowed_origins cannot contain *, list the origins that may call the API",
+			})
+			continue
+		}
+		if _, err := NormalizeOrigin(origin); err != nil {
+			issues = append(issues, ValidationIssue{
+				Severity: SeverityError,
+				Path:     "allowed_origins",
+				Message:  err.Error(),
+			})
+		}
+	}
+	for _, host := range s.AllowedHosts {
+		if !ValidHostName(host) {
+			issues = append(issues, ValidationIssue{
+				Severity: SeverityError,
+				Path:     "allowed_hosts",
+				Message:  fmt.Sprintf("invalid host name %q: use a name or IP address without scheme or port, e.g. manager.example.com", host),
+			})
+		}
+	}
+	return issues
+}
+
+// ValidHostName reports whether host is an IP address or a DNS name.
+func ValidHostName(host string) bool {
+	if _, err := netip.ParseAddr(host); err == nil {
+		return true
+	}
+	return hostNamePattern.MatchString(host)
+}
+
+func (s *WebSettings) validateSocket(path string) []ValidationIssue {
+	var issues []ValidationIssue
+	if !filepath.IsAbs(path) {
+		issues = append(issues, ValidationIssue{
+			Severity: SeverityError,
+			Path:     "host_address",
+			Message:  fmt.Sprintf("invalid host_address %q: the socket path must be absolute", s.HostAddress),
+		})
+	}
+	if _, err := s.SocketFileMode(); err != nil {
+		issues = append(issues, ValidationIssue{
+			Severity: SeverityError,
+			Path:     "socket_mode",
+			Message:  err.Error(),
+		})
+	}
+	if _, err := s.SocketRoleMap(); err != nil {
+		issues = append(issues, ValidationIssue{
+			Severity: SeverityError,
+			Path:     "socket_roles",
+			Message:  err.Error(),
+		})
+	}
+	if len(s.AllowedIPs) > 0 {
+		issues = append(issues, ValidationIssue{
+			Severity: SeverityError,
+			Path:     "allowed_ips",
+			Message:  "allowed_ips does not apply to unix sockets, use socket_mode and socket_roles",
+		})
+	}
+	return issues
+}
+
+// NormalizeOrigin checks that origin is a scheme and host without a path,
+// such as https://admin.example.com:8443, and returns it in lower case
+// without a trailing slash.
+func NormalizeOrigin(origin string) (string, error) {
+	u, err := url.Parse(strings.TrimSuffix(origin, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", fmt.Errorf("invalid origin %q: use scheme://host[:port], e.g. https://admin.example.com", origin)
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), nil
+}
+
+type FeishuSettings struct {
+	AppID     string `json:"app_id,omitempty" schema:"required" desc:"Feishu app id"`
+	AppSecret string `json:"app_secret,omitempty" secret:"true" schema:"required" desc:"Feishu app secret"`
+}
+
+func (s *FeishuSettings) Validate(enabled bool) []ValidationIssue {
+	return nil
+}
+
+func init() {
+	RegisterChannelType(ChannelType{
+		Name:     ChannelTypeWeb,
+		Title:    "Web",
+		Settings: func() ChannelSettings { return &WebSettings{} },
+	})
+	RegisterChannelType(ChannelType{
+		Name:     ChannelTypeFeishu,
+		Title:    "Feishu",
+		Settings: func() ChannelSettings { return &FeishuSettings{} },
+	})
+}
+
+// channelSchemas adds one conditional branch per registered channel type to
+// the channel schema: the type's fields, and its required fields once the
+// channel is enabled. The type itself is not restricted: channels of types
+// that are not registered match no branch and keep their settings as they
+// are.
+func channelSchemas(channel *Schema) {
+	for _, name := range ChannelTypeNames() {
+		t, _ := LookupChannelType(name)
+		settings := schemaFor(reflect.TypeOf(t.Settings()).Elem())
+
+		properties := make(map[string]*Schema, len(settings.Properties))
+		nonEmpty := make(map[string]*Schema)
+		for field, prop := range settings.Properties {
+			if prop.MinLength != nil {
+				nonEmpty[field] = &Schema{MinLength: prop.MinLength}
+				cp := *prop
+				cp.MinLength = nil
+				prop = &cp
+			}
+			properties[field] = prop
+		}
+
+		channel.AllOf = append(channel.AllOf, &Schema{
+			If: &Schema{
+				Properties: map[string]*Schema{"type": {Const: name}},
+				Required:   []string{"type"},
+			},
+			Then: &Schema{Properties: properties},
+		})
+		if len(settings.Required) == 0 {
+			continue
+		}
+		required := append([]string(nil), settings.Required...)
+		sort.Strings(required)
+		channel.AllOf = append(channel.AllOf, &Schema{
+			If: &Schema{
+				Properties: map[string]*Schema{
+					"type":    {Const: name},
+					"enabled": {Const: true},
+				},
+				Required: []string{"type", "enabled"},
+			},
+			Then: &Schema{Properties: nonEmpty, Required: required},
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParseAllowedIPs(t *testing.T) {
+	tests := []struct {
+		entry string
+		want  string
+	}{
+		{"192.168.1.0/24", "192.168.1.0/24"},
+		{"192.168.1.7/24", "192.168.1.0/24"},
+		{"10.0.0.5", "10.0.0.5/32"},
+		{"::ffff:10.0.0.5", "10.0.0.5/32"},
+		{"2001:db8::/32", "2001:db8::/32"},
+		{"::1", "::1/128"},
+		{"::ffff:10.0.0.0/104", "10.0.0.0/8"},
+	}
+	for _, tt := range tests {
+		prefixes, err := ParseAllowedIPs([]string{tt.entry})
+		if err != nil {
+			t.Errorf("ParseAllowedIPs(%q): %v", tt.entry, err)
+			continue
+		}
+		if want := netip.MustParsePrefix(tt.want); prefixes[0] != want {
+			t.Errorf("ParseAllowedIPs(%q) = %v, want %v", tt.entry, prefixes[0], want)
+		}
+	}
+
+	for _, entry := range []string{"", "example.com", "10.0.0.0/33", "10.0.0.256"} {
+		if _, err := ParseAllowedIPs([]string{entry}); err == nil {
+			t.Errorf("ParseAllowedIPs(%q) succeeded, want an error", entry)
+		}
+	}
+}
+
+func TestSocketRoleMap(t *testing.T) {
+	web := &WebSettings{SocketRoles: []string{"1000:admin", "1001:viewer", "0:operator"}}
+	roles, err := web.SocketRoleMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]string{1000: "admin", 1001: "viewer", 0: "operator"}
+	if len(roles) != len(want) {
+		t.Fatalf("SocketRoleMap() = %v, want %v", roles, want)
+	}
+	for uid, role := range want {
+		if roles[uid] != role {
+			t.Errorf("SocketRoleMap()[%d] = %q, want %q", uid, roles[uid], role)
+		}
+	}
+
+	for _, entry := range []string{"1000", "alice:admin", "-1:admin", "1000:root", "1000:"} {
+		web := &WebSettings{SocketRoles: []string{entry}}
+		if _, err := web.SocketRoleMap(); err == nil {
+			t.Errorf("SocketRoleMap(%q) succeeded, want an error", entry)
+		}
+	}
+}
@@ -1,14 +1,13 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 )
 
 func LoadConfig(cfgFilePath string) (*Config, error) {
-	cfgPath := expandPath(cfgFilePath)
+	cfgPath := ExpandPath(cfgFilePath)
 
 	data, err := os.ReadFile(cfgPath)
 	if err != nil {
@@ -16,7 +15,7 @@ func LoadConfig(cfgFilePath string) (*Config, error) {
 	}
 
 	var cfg Config
-	if err := json.Unmarshal(data, &cfg); err != nil {
+	if err := Unmarshal(DetectFormat(cfgPath), data, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
@@ -24,9 +23,16 @@ func LoadConfig(cfgFilePath string) (*Config, error) {
 }
 
 func SaveConfig(cfgFilePath string, cfg *Config) error {
-	cfgPath := expandPath(cfgFilePath)
+	cfgPath := ExpandPath(cfgFilePath)
 
-	data, err := json.MarshalIndent(cfg, "", "  ")
+	format := DetectFormat(cfgPath)
+
+	previous, err := os.ReadFile(cfgPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	data, err := Marshal(format, cfg, previous)
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
@@ -43,7 +49,18 @@ func SaveConfig(cfgFilePath string, cfg *Config) error {
 	return nil
 }
 
-func expandPath(path string) string {
+// ConvertConfig reads the config at srcPath and writes it to dstPath, each in
+// the format implied by its extension.
+func ConvertConfig(srcPath, dstPath string) error {
+	cfg, err := LoadConfig(srcPath)
+	if err != nil {
+		return err
+	}
+	return SaveConfig(dstPath, cfg)
+}
+
+// ExpandPath replaces a leading ~ in path with the home directory.
+func ExpandPath(path string) string {
 	if len(path) > 0 && path[0] == '~' {
 		home, err := os.UserHomeDir()
 		if err != nil {
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+
+	maskedSecret = "******"
+)
+
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+type EntryChange struct {
+	Name   string        `json:"name"`
+	Change string        `json:"change"`
+	Fields []FieldChange `json:"fields,omitempty"`
+	Value  interface{}   `json:"value,omitempty"`
+}
+
+type ConfigDiff struct {
+	Agents    []EntryChange `json:"agents"`
+	Providers []EntryChange `json:"providers"`
+	Channels  []EntryChange `json:"channels"`
+	Skill     []FieldChange `json:"skill"`
+	// RequiresRestart is set when the wangshu instance has to be restarted to
+	// pick up the change. Changes to the listener settings of web channels
+	// are applied by the manager alone and do not count.
+	RequiresRestart bool `json:"requires_restart"`
+	// RebindListeners lists the web channels whose manager listener has to be
+	// started, stopped or moved to another address.
+	RebindListeners []string `json:"rebind_listeners"`
+	RequiresRebind  bool     `json:"requires_rebind"`
+}
+
+func (d *ConfigDiff) Empty() bool {
+	return len(d.Agents) == 0 && len(d.Providers) == 0 && len(d.Channels) == 0 && len(d.Skill) == 0
+}
+
+// Diff compares the live config with a proposed one. Secret fields are masked
+// in the result so it can be returned to API clients as is.
+func Diff(live, proposed *Config) *ConfigDiff {
+	d := &ConfigDiff{
+		Agents:          diffEntries(live.Agents, proposed.Agents),
+		Providers:       diffEntries(live.Providers, proposed.Providers),
+		Channels:        diffEntries(live.Channels, proposed.Channels),
+		Skill:           diffFields(live.Skill, proposed.Skill),
+		RebindListeners: []string{},
+	}
+	if d.Skill == nil {
+		d.Skill = []FieldChange{}
+	}
+	d.RequiresRestart = len(d.Agents) > 0 || len(d.Providers) > 0 || len(d.Skill) > 0
+	for _, change := range d.Channels {
+		if !listenerOnlyChange(live.Channels[change.Name], proposed.Channels[change.Name], change) {
+			d.RequiresRestart = true
+		}
+	}
+
+	for _, name := range sortedKeys(mergeKeys(live.Channels, proposed.Channels)) {
+		oldCh, oldOk := live.Channels[name]
+		newCh, newOk := proposed.Channels[name]
+		if listenerAddress(oldCh, oldOk) != listenerAddress(newCh, newOk) {
+			d.RebindListeners = append(d.RebindListeners, name)
+		}
+	}
+	d.RequiresRebind = len(d.RebindListeners) > 0
+
+	return d
+}
+
+// listenerOnlyChange reports whether change only touches the settings of a
+// web channel, which the manager applies to its listeners without wangshu.
+func listenerOnlyChange(oldCh, newCh ChannelConfig, change EntryChange) bool {
+	_, oldWeb := oldCh.Web()
+	_, newWeb := newCh.Web()
+	if change.Change != ChangeChanged || !oldWeb || !newWeb {
+		return false
+	}
+	for _, f := range change.Fields {
+		if !webSettingsFields[f.Field] {
+			return false
+		}
+	}
+	return true
+}
+
+// webSettingsFields holds the field names of WebSettings.
+var webSettingsFields = func() map[string]bool {
+	fields := make(map[string]bool)
+	for _, f := range leafFields(reflect.ValueOf(WebSettings{})) {
+		fields[f.name] = true
+	}
+	return fields
+}()
+
+func listenerAddress(ch ChannelConfig, ok bool) string {
+	web, isWeb := ch.Web()
+	if !ok || !isWeb || !ch.Enabled {
+		return ""
+	}
+	return web.Address()
+}
+
+func diffEntries[V any](live, proposed map[string]V) []EntryChange {
+	changes := []EntryChange{}
+	for _, name := range sortedKeys(mergeKeys(live, proposed)) {
+		oldV, oldOk := live[name]
+		newV, newOk := proposed[name]
+		switch {
+		case !oldOk:
+			changes = append(changes, EntryChange{Name: name, Change: ChangeAdded, Value: MaskSecrets(newV)})
+		case !newOk:
+			changes = append(changes, EntryChange{Name: name, Change: ChangeRemoved, Value: MaskSecrets(oldV)})
+		default:
+			if fields := diffFields(oldV, newV); len(fields) > 0 {
+				changes = append(changes, EntryChange{Name: name, Change: ChangeChanged, Fields: fields})
+			}
+		}
+	}
+	return changes
+}
+
+// diffFields compares two entries of the same struct type field by field.
+// Inline payloads are compared by their own fields, so a changed channel
+// type shows up as the fields that appeared and disappeared.
+func diffFields(oldV, newV interface{}) []FieldChange {
+	oldFields := leafFields(reflect.ValueOf(oldV))
+	newFields := leafFields(reflect.ValueOf(newV))
+
+	type pair struct {
+		old, new interface{}
+		secret   bool
+	}
+	var order []string
+	pairs := make(map[string]*pair)
+	get := func(name string) *pair {
+		p, ok := pairs[name]
+		if !ok {
+			p = &pair{}
+			pairs[name] = p
+			order = append(order, name)
+		}
+		return p
+	}
+	for _, f := range oldFields {
+		p := get(f.name)
+		p.old = f.value.Interface()
+		p.secret = p.secret || f.secret
+	}
+	for _, f := range newFields {
+		p := get(f.name)
+		p.new = f.value.Interface()
+		p.secret = p.secret || f.secret
+	}
+
+	var fields []FieldChange
+	for _, name := range order {
+		p := pairs[name]
+		if reflect.DeepEqual(p.old, p.new) {
+			continue
+		}
+		o, n := p.old, p.new
+		if p.secret {
+			o = maskValue(o)
+			n = maskValue(n)
+		}
+		fields = append(fields, FieldChange{Field: name, Old: o, New: n})
+	}
+	return fields
+}
+
+// MaskSecrets returns a copy of v, which must be a struct, with every field
+// tagged `secret:"true"` replaced by a placeholder.
+func MaskSecrets[T any](v T) T {
+	for _, f := range secretFields(reflect.ValueOf(&v).Elem()) {
+		if f.get() != "" {
+			f.set(maskedSecret)
+		}
+	}
+	return v
+}
+
+func maskValue(v interface{}) interface{} {
+	if s, ok := v.(string); (ok && s == "") || v == nil {
+		return v
+	}
+	return maskedSecret
+}
+
+func jsonFieldName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+func mergeKeys[V any](a, b map[string]V) map[string]struct{} {
+	keys := make(map[string]struct{}, len(a)+len(b))
+	for k := range a {
+		keys[k] = struct{}{}
+	}
+	for k := range b {
+		keys[k] = struct{}{}
+	}
+	return keys
+}
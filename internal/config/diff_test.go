@@ -0,0 +1,68 @@
+package config
+
+import "testing"
+
+func TestDiffRequiresRestart(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		restart bool
+		rebind  bool
+	}{
+		{"unchanged", func(c *Config) {}, false, false},
+		{"web listener address", func(c *Config) {
+			c.Channels["web"] = webChannel(true, "localhost:9090", "tok")
+		}, false, true},
+		{"web token", func(c *Config) {
+			c.Channels["web"] = webChannel(true, "localhost:8080", "other")
+		}, false, false},
+		{"web agent", func(c *Config) {
+			ch := webChannel(true, "localhost:8080", "tok")
+			ch.Agent = "other"
+			c.Channels["web"] = ch
+		}, true, false},
+		{"web channel disabled", func(c *Config) {
+			c.Channels["web"] = webChannel(false, "localhost:8080", "tok")
+		}, true, true},
+		{"web channel added", func(c *Config) {
+			c.Channels["web2"] = webChannel(true, "localhost:9090", "tok")
+		}, true, true},
+		{"agent model", func(c *Config) {
+			c.Agents["default"] = AgentConfig{Workspace: "ws", Provider: "p", Model: "other"}
+		}, true, false},
+		{"skill path", func(c *Config) {
+			c.Skill.GlobalPath = "/skills"
+		}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			live := diffTestConfig()
+			proposed := diffTestConfig()
+			tt.change(proposed)
+			d := Diff(live, proposed)
+			if d.RequiresRestart != tt.restart {
+				t.Errorf("RequiresRestart = %v, want %v", d.RequiresRestart, tt.restart)
+			}
+			if d.RequiresRebind != tt.rebind {
+				t.Errorf("RequiresRebind = %v, want %v", d.RequiresRebind, tt.rebind)
+			}
+		})
+	}
+}
+
+func diffTestConfig() *Config {
+	return &Config{
+		Agents:    map[string]AgentConfig{"default": {Workspace: "ws", Provider: "p", Model: "m"}},
+		Providers: map[string]ProviderConfig{"p": {Type: "openai", APIKey: "key"}},
+		Channels:  map[string]ChannelConfig{"web": webChannel(true, "localhost:8080", "tok")},
+	}
+}
+
+func webChannel(enabled bool, address, token string) ChannelConfig {
+	return ChannelConfig{
+		Type:     ChannelTypeWeb,
+		Enabled:  enabled,
+		Agent:    "default",
+		Settings: &WebSettings{HostAddress: address, Token: token},
+	}
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// leafField is an exported field of a config struct. Fields of payloads
+// tagged `config:"inline"` are listed in place of the payload itself.
+type leafField struct {
+	name   string
+	secret bool
+	value  reflect.Value
+}
+
+// leafFields lists the fields of the struct rv. When rv is addressable,
+// inline pointer payloads are first replaced by private copies, so a caller
+// working on a by-value copy of a config entry can modify the returned values
+// without touching the entry it was copied from.
+func leafFields(rv reflect.Value) []leafField {
+	var fields []leafField
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		fv := rv.Field(i)
+
+		if f.Tag.Get("config") == "inline" {
+			payload := fv
+			if payload.Kind() == reflect.Interface {
+				payload = payload.Elem()
+			}
+			if !payload.IsValid() {
+				continue
+			}
+			if payload.Kind() != reflect.Pointer || payload.Elem().Kind() != reflect.Struct {
+				fields = append(fields, leafField{name: "settings", secret: len(rawSecretKeys(payload)) > 0, value: fv})
+				continue
+			}
+			if fv.CanSet() {
+				cp := reflect.New(payload.Elem().Type())
+				cp.Elem().Set(payload.Elem())
+				fv.Set(cp)
+				payload = cp
+			}
+			fields = append(fields, leafFields(payload.Elem())...)
+			continue
+		}
+
+		fields = append(fields, leafField{name: jsonFieldName(f), secret: isSecretField(f), value: fv})
+	}
+	return fields
+}
+
+func isSecretField(f reflect.StructField) bool {
+	return f.Tag.Get("secret") == "true"
+}
+
+// secretField is a credential of a config entry: a string field tagged
+// `secret:"true"`, or a string setting of an unregistered channel type whose
+// name looks like one. set may only be called when the entry is addressable.
+type secretField struct {
+	name string
+	get  func() string
+	set  func(string)
+}
+
+// secretFields lists the credentials of the struct rv. Like leafFields it
+// replaces shared payloads with private copies before they are modified.
+func secretFields(rv reflect.Value) []secretField {
+	var fields []secretField
+	for _, f := range leafFields(rv) {
+		if f.value.Kind() == reflect.Interface {
+			fields = append(fields, rawSecretFields(f.value)...)
+			continue
+		}
+		if f.secret && f.value.Kind() == reflect.String {
+			fields = append(fields, secretField{name: f.name, get: f.value.String, set: f.value.SetString})
+		}
+	}
+	return fields
+}
+
+// rawSecretFields lists the secret keys of the RawChannelSettings held by
+// the interface value v. Unregistered types declare no secret fields, so any
+// setting named like a credential is treated as one to keep it out of
+// exports and diffs.
+func rawSecretFields(v reflect.Value) []secretField {
+	var fields []secretField
+	for _, key := range rawSecretKeys(v.Elem()) {
+		current := func() RawChannelSettings {
+			return *v.Interface().(*RawChannelSettings)
+		}
+		fields = append(fields, secretField{
+			name: key,
+			get: func() string {
+				var value string
+				json.Unmarshal(current()[key], &value)
+				return value
+			},
+			set: func(value string) {
+				cp := make(RawChannelSettings, len(current()))
+				for k, raw := range current() {
+					cp[k] = raw
+				}
+				cp[key], _ = json.Marshal(value)
+				v.Set(reflect.ValueOf(&cp))
+			},
+		})
+	}
+	return fields
+}
+
+// rawSecretKeys returns the keys of the *RawChannelSettings payload that
+// hold a string and are named like a credential.
+func rawSecretKeys(payload reflect.Value) []string {
+	raw, ok := payload.Interface().(*RawChannelSettings)
+	if !ok || raw == nil {
+		return nil
+	}
+	var keys []string
+	for _, key := range sortedKeys(*raw) {
+		var value string
+		if looksSecret(key) && json.Unmarshal((*raw)[key], &value) == nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+var secretNameParts = []string{"secret", "token", "password", "passwd", "key", "credential"}
+
+func looksSecret(name string) bool {
+	name = strings.ToLower(name)
+	for _, part := range secretNameParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
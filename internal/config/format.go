@@ -0,0 +1,218 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+	FormatTOML Format = "toml"
+)
+
+// DetectFormat picks the config format from the file extension. Paths
+// without a known extension are JSON, the format wangshu config files have
+// always been in.
+func DetectFormat(cfgFilePath string) Format {
+	switch strings.ToLower(filepath.Ext(cfgFilePath)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatJSON
+	}
+}
+
+// Unmarshal decodes data in the given format into cfg. YAML and TOML are
+// decoded into generic values first and then mapped through the json tags,
+// so Config only has to describe its field names once.
+func Unmarshal(format Format, data []byte, cfg *Config) error {
+	if format == FormatJSON {
+		return json.Unmarshal(data, cfg)
+	}
+
+	var raw map[string]interface{}
+	switch format {
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+	case FormatTOML:
+		if err := toml.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported config format %q", format)
+	}
+
+	jsonData, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonData, cfg)
+}
+
+// Marshal encodes cfg in the given format. When previous holds the current
+// content of a YAML file, comments and key order from it are carried over.
+func Marshal(format Format, cfg *Config, previous []byte) ([]byte, error) {
+	jsonData, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatJSON:
+		return jsonData, nil
+	case FormatYAML:
+		return marshalYAML(jsonData, previous)
+	case FormatTOML:
+		raw, err := genericValue(jsonData)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(raw); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+}
+
+func marshalYAML(jsonData, previous []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(jsonData, &doc); err != nil {
+		return nil, err
+	}
+	resetStyle(&doc)
+
+	if len(bytes.TrimSpace(previous)) > 0 {
+		var prev yaml.Node
+		if err := yaml.Unmarshal(previous, &prev); err == nil && prev.Kind == yaml.DocumentNode {
+			mergeNode(&prev, &doc)
+			doc = prev
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// resetStyle drops the flow and quoting styles the JSON input comes with so
+// the output looks like hand written block YAML.
+func resetStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		resetStyle(c)
+	}
+}
+
+// mergeNode rewrites dst in place to hold the values of src while keeping the
+// comments and key order of dst. Keys missing from src are removed, new keys
+// are appended.
+func mergeNode(dst, src *yaml.Node) {
+	if dst.Kind != src.Kind || (dst.Kind != yaml.DocumentNode && dst.Kind != yaml.MappingNode) {
+		head, line, foot := dst.HeadComment, dst.LineComment, dst.FootComment
+		*dst = *src
+		dst.HeadComment, dst.LineComment, dst.FootComment = head, line, foot
+		return
+	}
+
+	if dst.Kind == yaml.DocumentNode {
+		if len(dst.Content) == 0 {
+			dst.Content = src.Content
+			return
+		}
+		mergeNode(dst.Content[0], src.Content[0])
+		return
+	}
+
+	srcValues := make(map[string]*yaml.Node, len(src.Content)/2)
+	var srcOrder []string
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		srcValues[src.Content[i].Value] = src.Content[i+1]
+		srcOrder = append(srcOrder, src.Content[i].Value)
+	}
+
+	merged := make([]*yaml.Node, 0, len(src.Content))
+	seen := make(map[string]bool, len(srcValues))
+	for i := 0; i+1 < len(dst.Content); i += 2 {
+		key := dst.Content[i]
+		value, ok := srcValues[key.Value]
+		if !ok {
+			continue
+		}
+		mergeNode(dst.Content[i+1], value)
+		merged = append(merged, key, dst.Content[i+1])
+		seen[key.Value] = true
+	}
+	for i, key := range srcOrder {
+		if !seen[key] {
+			merged = append(merged, src.Content[2*i], src.Content[2*i+1])
+		}
+	}
+	dst.Content = merged
+}
+
+// genericValue decodes JSON into plain maps, slices and scalars suitable for
+// the TOML encoder, which cannot represent nulls and would print every number
+// as a float.
+func genericValue(jsonData []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(jsonData))
+	dec.UseNumber()
+	var raw interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return normalize(raw), nil
+}
+
+func normalize(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			if item == nil {
+				delete(val, k)
+				continue
+			}
+			val[k] = normalize(item)
+		}
+		return val
+	case []interface{}:
+		for i, item := range val {
+			val[i] = normalize(item)
+		}
+		return val
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i
+		}
+		f, _ := val.Float64()
+		if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return int64(f)
+		}
+		return f
+	default:
+		return v
+	}
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := map[string]Format{
+		"config.json":     FormatJSON,
+		"config.YAML":     FormatYAML,
+		"config.yml":      FormatYAML,
+		"config.toml":     FormatTOML,
+		"config":          FormatJSON,
+		"config.conf":     FormatJSON,
+		"/etc/wangshu/rc": FormatJSON,
+	}
+	for path, want := range tests {
+		if got := DetectFormat(path); got != want {
+			t.Errorf("DetectFormat(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestSaveLoadConfigWithoutExtension(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := SaveConfig(path, diffTestConfig()); err != nil {
+		t.Fatalf("SaveConfig: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) == 0 || data[0] != '{' {
+		t.Fatalf("config without extension was not written as JSON: %s", data)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Agents["default"].Model != "m" {
+		t.Errorf("loaded agent = %+v", cfg.Agents["default"])
+	}
+	if web, ok := cfg.Channels["web"].Web(); !ok || web.Token != "tok" {
+		t.Errorf("loaded web channel = %+v", cfg.Channels["web"])
+	}
+}
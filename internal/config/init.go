@@ -0,0 +1,70 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// GenerateToken returns a random hex token suitable for web channel auth.
+func GenerateToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// InitConfig writes the default config to cfgFilePath with the web channel
+// enabled behind a freshly generated token, and creates the agent workspaces
+// and the global skills directory. An existing file is only replaced when
+// force is set.
+func InitConfig(cfgFilePath string, force bool) (*Config, error) {
+	cfgPath := ExpandPath(cfgFilePath)
+
+	if _, err := os.Stat(cfgPath); err == nil && !force {
+		return nil, fmt.Errorf("config file %s already exists", cfgPath)
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to stat config file: %w", err)
+	}
+
+	cfg := defaultConfig()
+	for name, channel := range cfg.Channels {
+		web, ok := channel.Web()
+		if !ok {
+			continue
+		}
+		token, err := GenerateToken()
+		if err != nil {
+			return nil, err
+		}
+		settings := *web
+		settings.Token = token
+		channel.Enabled = true
+		channel.Settings = &settings
+		cfg.Channels[name] = channel
+	}
+
+	for name, agent := range cfg.Agents {
+		if err := os.MkdirAll(ExpandPath(agent.Workspace), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create workspace for agent %s: %w", name, err)
+		}
+	}
+	if cfg.Skill.GlobalPath != "" {
+		if err := os.MkdirAll(ExpandPath(cfg.Skill.GlobalPath), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create skills directory: %w", err)
+		}
+	}
+
+	if err := SaveConfig(cfgFilePath, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Exists reports whether a config file is present at cfgFilePath.
+func Exists(cfgFilePath string) bool {
+	_, err := os.Stat(ExpandPath(cfgFilePath))
+	return err == nil
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const schemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is the subset of JSON Schema used to describe Config. The same
+// document is served to clients and used by Validate.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Const                interface{}        `json:"const,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	If                   *Schema            `json:"if,omitempty"`
+	Then                 *Schema            `json:"then,omitempty"`
+	Secret               bool               `json:"x-secret,omitempty"`
+}
+
+var (
+	configSchema     *Schema
+	configSchemaOnce sync.Once
+)
+
+// ConfigSchema returns the JSON Schema of Config.
+func ConfigSchema() *Schema {
+	configSchemaOnce.Do(func() {
+		configSchema = buildConfigSchema()
+	})
+	return configSchema
+}
+
+func buildConfigSchema() *Schema {
+	s := schemaFor(reflect.TypeOf(Config{}))
+	s.Schema = schemaDraft
+	s.Title = "wangshu config"
+
+	provider := s.Properties["providers"].AdditionalProperties
+	provider.Properties["type"].Enum = stringsToEnum(ProviderTypes)
+
+	channelSchemas(s.Properties["channels"].AdditionalProperties)
+
+	return s
+}
+
+// schemaFor maps a Go type onto a schema using its json tags. String fields
+// tagged `schema:"required"` must be present and non-empty.
+func schemaFor(t reflect.Type) *Schema {
+	switch t.Kind() {
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() || f.Tag.Get("json") == "-" {
+				continue
+			}
+			name := jsonFieldName(f)
+			prop := schemaFor(f.Type)
+			prop.Secret = isSecretField(f)
+			if f.Tag.Get("schema") == "required" {
+				s.Required = append(s.Required, name)
+				if prop.Type == "string" {
+					prop.MinLength = intPtr(1)
+				}
+			}
+			s.Properties[name] = prop
+		}
+		return s
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaFor(t.Elem())}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Pointer:
+		return schemaFor(t.Elem())
+	default:
+		return &Schema{}
+	}
+}
+
+// ValidateValue checks a decoded JSON value against the schema and returns
+// one error issue per violation, keyed by dotted path.
+func (s *Schema) ValidateValue(value interface{}) []ValidationIssue {
+	var issues []ValidationIssue
+	s.validate(value, "", &issues)
+	return issues
+}
+
+func (s *Schema) validate(value interface{}, path string, issues *[]ValidationIssue) {
+	fail := func(p, format string, args ...interface{}) {
+		*issues = append(*issues, ValidationIssue{Severity: SeverityError, Path: p, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if value == nil {
+		return
+	}
+	if s.Type != "" && !matchesType(s.Type, value) {
+		fail(path, "must be of type %s", s.Type)
+		return
+	}
+	if s.Const != nil && !reflect.DeepEqual(s.Const, value) {
+		fail(path, "must be %v", s.Const)
+	}
+	if len(s.Enum) > 0 && !enumContains(s.Enum, value) {
+		fail(path, "must be one of %s", enumString(s.Enum))
+	}
+	if str, ok := value.(string); ok && s.MinLength != nil && len(str) < *s.MinLength {
+		fail(path, "must not be empty")
+	}
+
+	if obj, ok := value.(map[string]interface{}); ok {
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				fail(joinPath(path, name), "is required")
+			}
+		}
+		for _, name := range sortedKeys(obj) {
+			if prop, ok := s.Properties[name]; ok {
+				prop.validate(obj[name], joinPath(path, name), issues)
+			} else if s.AdditionalProperties != nil {
+				s.AdditionalProperties.validate(obj[name], joinPath(path, name), issues)
+			}
+		}
+	}
+
+	for _, sub := range s.AllOf {
+		sub.validate(value, path, issues)
+	}
+	if s.If != nil && s.Then != nil && len(s.If.ValidateValue(value)) == 0 {
+		s.Then.validate(value, path, issues)
+	}
+}
+
+func matchesType(t string, value interface{}) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == float64(int64(f))
+	}
+	return true
+}
+
+func enumContains(enum []interface{}, value interface{}) bool {
+	for _, v := range enum {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func enumString(enum []interface{}) string {
+	parts := make([]string, 0, len(enum))
+	for _, v := range enum {
+		parts = append(parts, fmt.Sprint(v))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func stringsToEnum(values []string) []interface{} {
+	enum := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		enum = append(enum, v)
+	}
+	return enum
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+// toGeneric converts cfg into the plain maps the schema validator works on.
+func toGeneric(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
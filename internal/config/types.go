@@ -20,28 +20,25 @@ type SkillConfig struct {
 }
 
 type AgentConfig struct {
-	Workspace   string  `json:"workspace"`
-	Provider    string  `json:"provider"`
-	Model       string  `json:"model"`
+	Workspace   string  `json:"workspace" schema:"required"`
+	Provider    string  `json:"provider" schema:"required"`
+	Model       string  `json:"model" schema:"required"`
 	Temperature float64 `json:"temperature"`
 }
 
 type ProviderConfig struct {
-	Type    string `json:"type"` // openai/anthropic/ollama/...
-	APIKey  string `json:"api_key"`
+	Type    string `json:"type" schema:"required"` // openai/anthropic/ollama/...
+	APIKey  string `json:"api_key" secret:"true"`
 	BaseURL string `json:"base_url,omitempty"`
 }
 
 type ChannelConfig struct {
-	Type    string `json:"type"`
+	Type    string `json:"type" schema:"required"`
 	Enabled bool   `json:"enabled"`
-	Agent   string `json:"agent"`
-	// feishu
-	AppID     string `json:"app_id,omitempty"`
-	AppSecret string `json:"app_secret,omitempty"`
-	// web
-	HostAddress string `json:"host_address,omitempty"`
-	Token       string `json:"token,omitempty"`
+	Agent   string `json:"agent" schema:"required"`
+	// Settings holds the fields specific to Type, see RegisterChannelType.
+	// They are stored flat next to the fields above.
+	Settings ChannelSettings `json:"-" config:"inline"`
 }
 
 func defaultConfig() *Config {
@@ -63,18 +60,22 @@ func defaultConfig() *Config {
 		},
 		Channels: map[string]ChannelConfig{
 			"feishuTest": ChannelConfig{
-				Type:      "feishu",
-				Enabled:   false,
-				Agent:     constant.Default,
-				AppID:     "your feishu app id",
-				AppSecret: "your feishu app secret",
+				Type:    ChannelTypeFeishu,
+				Enabled: false,
+				Agent:   constant.Default,
+				Settings: &FeishuSettings{
+					AppID:     "your feishu app id",
+					AppSecret: "your feishu app secret",
+				},
 			},
 			"webTest": ChannelConfig{
-				Type:        "web",
-				Enabled:     false,
-				Agent:       constant.Default,
-				HostAddress: "localhost:8080",
-				Token:       "custom defined token",
+				Type:    ChannelTypeWeb,
+				Enabled: false,
+				Agent:   constant.Default,
+				Settings: &WebSettings{
+					HostAddress: "localhost:8080",
+					Token:       "custom defined token",
+				},
 			},
 		},
 		Skill: SkillConfig{
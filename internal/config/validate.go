@@ -0,0 +1,156 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+var ProviderTypes = []string{"openai", "anthropic", "ollama"}
+
+type ValidationIssue struct {
+	Severity string `json:"severity"`
+	Path     string `json:"path"`
+	Message  string `json:"message"`
+}
+
+type ValidationResult struct {
+	Valid  bool              `json:"valid"`
+	Issues []ValidationIssue `json:"issues"`
+}
+
+func (r *ValidationResult) add(severity, path, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, ValidationIssue{
+		Severity: severity,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	})
+	if severity == SeverityError {
+		r.Valid = false
+	}
+}
+
+// ModelCatalog reports the models a provider is known to offer. ok is false
+// when the provider's models have not been listed yet.
+type ModelCatalog interface {
+	KnownModels(providerName string, provider ProviderConfig) (models []string, ok bool)
+}
+
+// Validate checks cfg against ConfigSchema and then checks the cross
+// references the schema cannot express. Errors make the config unusable,
+// warnings point at settings that are likely wrong.
+func Validate(cfg *Config) *ValidationResult {
+	return ValidateWithCatalog(cfg, nil)
+}
+
+// ValidateWithCatalog is Validate plus a warning for every agent model the
+// catalog does not list for the agent's provider.
+func ValidateWithCatalog(cfg *Config, catalog ModelCatalog) *ValidationResult {
+	generic, err := toGeneric(cfg)
+	if err != nil {
+		result := &ValidationResult{Valid: true, Issues: []ValidationIssue{}}
+		result.add(SeverityError, "", "failed to encode config: %v", err)
+		return result
+	}
+	return validate(generic, cfg, catalog)
+}
+
+// ValidateDocument is ValidateWithCatalog for a config a client sent as
+// JSON. The schema is checked against data as sent rather than against cfg,
+// the config decoded from it, which always has every key and so would never
+// miss a required one.
+func ValidateDocument(data []byte, cfg *Config, catalog ModelCatalog) *ValidationResult {
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		result := &ValidationResult{Valid: true, Issues: []ValidationIssue{}}
+		result.add(SeverityError, "", "invalid JSON: %v", err)
+		return result
+	}
+	return validate(generic, cfg, catalog)
+}
+
+func validate(generic interface{}, cfg *Config, catalog ModelCatalog) *ValidationResult {
+	result := &ValidationResult{Valid: true, Issues: []ValidationIssue{}}
+	for _, issue := range ConfigSchema().ValidateValue(generic) {
+		result.add(issue.Severity, issue.Path, "%s", issue.Message)
+	}
+
+	if len(cfg.Agents) == 0 {
+		result.add(SeverityError, "agents", "at least one agent is required")
+	}
+	for _, name := range sortedKeys(cfg.Agents) {
+		agent := cfg.Agents[name]
+		path := "agents." + name
+		if agent.Temperature < 0 || agent.Temperature > 2 {
+			result.add(SeverityWarning, path+".temperature", "temperature %.2f is outside the usual range 0-2", agent.Temperature)
+		}
+		if agent.Provider == "" {
+			continue
+		}
+		provider, ok := cfg.Providers[agent.Provider]
+		if !ok {
+			result.add(SeverityError, path+".provider", "provider %q does not exist", agent.Provider)
+			continue
+		}
+		if catalog == nil || agent.Model == "" {
+			continue
+		}
+		if models, ok := catalog.KnownModels(agent.Provider, provider); ok && !slices.Contains(models, agent.Model) {
+			result.add(SeverityWarning, path+".model", "model %q is not listed by provider %q", agent.Model, agent.Provider)
+		}
+	}
+
+	for _, name := range sortedKeys(cfg.Providers) {
+		provider := cfg.Providers[name]
+		if provider.APIKey == "" && provider.Type != "ollama" {
+			result.add(SeverityWarning, "providers."+name+".api_key", "api_key is empty")
+		}
+	}
+
+	addresses := make(map[string]string)
+	for _, name := range sortedKeys(cfg.Channels) {
+		channel := cfg.Channels[name]
+		path := "channels." + name
+		if _, ok := LookupChannelType(channel.Type); !ok && channel.Type != "" {
+			result.add(SeverityWarning, path+".type", "channel type %q is not known to the manager, its settings are kept but not checked", channel.Type)
+		}
+		if channel.Agent != "" {
+			if _, ok := cfg.Agents[channel.Agent]; !ok {
+				result.add(SeverityError, path+".agent", "agent %q does not exist", channel.Agent)
+			}
+		}
+		if channel.Settings != nil {
+			for _, issue := range channel.Settings.Validate(channel.Enabled) {
+				result.add(issue.Severity, joinPath(path, issue.Path), "%s", issue.Message)
+			}
+		}
+
+		web, ok := channel.Web()
+		if !ok || !channel.Enabled {
+			continue
+		}
+		addr := web.Address()
+		if other, ok := addresses[addr]; ok {
+			result.add(SeverityError, path+".host_address", "host_address %q is already used by channel %q", addr, other)
+		} else {
+			addresses[addr] = name
+		}
+	}
+
+	return result
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
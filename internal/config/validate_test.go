@@ -0,0 +1,114 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateDocumentReportsMissingRequiredKeys(t *testing.T) {
+	data := []byte(`{
+		"agents": {"default": {"workspace": "ws", "provider": "p"}},
+		"providers": {"p": {"api_key": "key"}},
+		"channels": {"web": {"type": "web", "enabled": true, "host_address": "localhost:8080", "token": "tok"}}
+	}`)
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	result := ValidateDocument(data, &cfg, nil)
+	if result.Valid {
+		t.Fatal("config without required keys is valid")
+	}
+	want := map[string]bool{
+		"agents.default.model": false,
+		"providers.p.type":     false,
+		"channels.web.agent":   false,
+	}
+	for _, issue := range result.Issues {
+		if _, ok := want[issue.Path]; ok && issue.Severity == SeverityError {
+			want[issue.Path] = true
+		}
+	}
+	for path, found := range want {
+		if !found {
+			t.Errorf("no error for missing %s in %+v", path, result.Issues)
+		}
+	}
+
+}
+
+func TestValidateDocumentAcceptsCompleteConfig(t *testing.T) {
+	data, err := json.Marshal(diffTestConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if result := ValidateDocument(data, &cfg, nil); !result.Valid {
+		t.Errorf("complete config is invalid: %+v", result.Issues)
+	}
+}
+
+func TestAllowedOriginsRejectsWildcard(t *testing.T) {
+	web := &WebSettings{AllowedOrigins: []string{"*"}}
+	for _, issue := range web.Validate(true) {
+		if issue.Path == "allowed_origins" && issue.Severity == SeverityError {
+			return
+		}
+	}
+	t.Error("allowed_origins [\"*\"] validated without an error")
+}
+
+func TestValidateDocumentWarnsAboutUnknownChannelTypes(t *testing.T) {
+	data := []byte(`{
+		"agents": {"default": {"workspace": "ws", "provider": "p", "model": "m"}},
+		"providers": {"p": {"type": "openai", "api_key": "key"}},
+		"channels": {"tg": {"type": "telegram", "enabled": true, "agent": "default", "bot_token": "123:abc"}}
+	}`)
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	result := ValidateDocument(data, &cfg, nil)
+	if !result.Valid {
+		t.Fatalf("config with a telegram channel is invalid: %+v", result.Issues)
+	}
+	for _, issue := range result.Issues {
+		if issue.Path == "channels.tg.type" && issue.Severity == SeverityWarning {
+			return
+		}
+	}
+	t.Errorf("no warning for the unknown channel type in %+v", result.Issues)
+}
+
+func TestAllowedHostsValidation(t *testing.T) {
+	tests := []struct {
+		host  string
+		valid bool
+	}{
+		{"manager.example.com", true},
+		{"manager", true},
+		{"192.168.1.10", true},
+		{"::1", true},
+		{"manager.example.com:8443", false},
+		{"https://manager.example.com", false},
+		{"*.example.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		web := &WebSettings{AllowedHosts: []string{tt.host}}
+		valid := true
+		for _, issue := range web.Validate(true) {
+			if issue.Path == "allowed_hosts" && issue.Severity == SeverityError {
+				valid = false
+			}
+		}
+		if valid != tt.valid {
+			t.Errorf("allowed_hosts [%q] valid = %v, want %v", tt.host, valid, tt.valid)
+		}
+	}
+}
@@ -21,6 +21,18 @@ type ProcessManager struct {
 	ctx            context.Context
 	cancel         context.CancelFunc
 	configPath     string
+	exited         chan struct{}
+	keepOnExit     bool
+	stats          Stats
+}
+
+// Stats counts the instances started by this manager and how they ended.
+type Stats struct {
+	Starts   int
+	Restarts int
+	// Exits counts exits by exit code; -1 stands for an instance that was
+	// killed by a signal.
+	Exits map[int]int
 }
 
 type InstanceStatus struct {
@@ -288,17 +300,33 @@ func (pm *ProcessManager) Start(autoStarted bool) error {
 	pm.cmd = exec.CommandContext(pm.ctx, execPath, args...)
 	pm.cmd.Stdout = os.Stdout
 	pm.cmd.Stderr = os.Stderr
+	if pm.keepOnExit {
+		pm.cmd.SysProcAttr = detachedProcAttr()
+	}
 
 	if err := pm.cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start wangshu: %w", err)
 	}
 
 	slog.Info("wangshu process started", "pid", pm.cmd.Process.Pid, "auto_started", autoStarted)
+	pm.stats.Starts++
 
+	cmd := pm.cmd
+	exited := make(chan struct{})
+	pm.exited = exited
 	go func() {
-		err := pm.cmd.Wait()
+		err := cmd.Wait()
+		close(exited)
 		pm.mu.Lock()
-		pm.cmd = nil
+		if pm.cmd == cmd {
+			pm.cmd = nil
+		}
+		if cmd.ProcessState != nil {
+			if pm.stats.Exits == nil {
+				pm.stats.Exits = make(map[int]int)
+			}
+			pm.stats.Exits[cmd.ProcessState.ExitCode()]++
+		}
 		pm.mu.Unlock()
 		if err != nil {
 			slog.Error("wangshu process exited", "error", err)
@@ -350,9 +378,25 @@ func (pm *ProcessManager) Restart() error {
 		return fmt.Errorf("failed to start wangshu after restart: %w", err)
 	}
 
+	pm.mu.Lock()
+	pm.stats.Restarts++
+	pm.mu.Unlock()
 	return nil
 }
 
+// Stats returns a copy of the counters of instances started by this manager.
+func (pm *ProcessManager) Stats() Stats {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	stats := pm.stats
+	stats.Exits = make(map[int]int, len(pm.stats.Exits))
+	for code, n := range pm.stats.Exits {
+		stats.Exits[code] = n
+	}
+	return stats
+}
+
 func (pm *ProcessManager) terminateProcess(pid int) error {
 	if runtime.GOOS == "windows" {
 		cmd := exec.Command("taskkill", "/F", "/PID", fmt.Sprintf("%d", pid))
@@ -414,7 +458,30 @@ func (pm *ProcessManager) AutoStartIfNotRunning() error {
 	return nil
 }
 
-func (pm *ProcessManager) Shutdown() {
+// SetKeepOnExit controls whether instances started from now on outlive the
+// manager. Kept instances run in their own process group, so a Ctrl-C in the
+// manager's terminal does not reach them.
+func (pm *ProcessManager) SetKeepOnExit(keep bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.keepOnExit = keep
+}
+
+// Shutdown asks the wangshu instance to stop and waits for an instance
+// started by this manager to exit. It is killed once ctx is done.
+func (pm *ProcessManager) Shutdown(ctx context.Context) error {
+	pm.mu.RLock()
+	exited := pm.exited
+	pm.mu.RUnlock()
+
+	err := pm.Stop()
+	if exited != nil {
+		select {
+		case <-exited:
+		case <-ctx.Done():
+			slog.Warn("wangshu did not exit in time, killing it")
+		}
+	}
 	pm.cancel()
-	pm.Stop()
+	return err
 }
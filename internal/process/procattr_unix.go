@@ -0,0 +1,9 @@
+//go:build !windows
+
+package process
+
+import "syscall"
+
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
@@ -0,0 +1,111 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+const DefaultCatalogTTL = time.Hour
+
+type ModelList struct {
+	Provider  string    `json:"provider"`
+	Models    []string  `json:"models"`
+	FetchedAt time.Time `json:"fetched_at"`
+	Cached    bool      `json:"cached"`
+}
+
+type catalogEntry struct {
+	fingerprint string
+	models      []string
+	fetchedAt   time.Time
+}
+
+// Catalog caches the model lists of providers. Entries are keyed by provider
+// name and invalidated when its type, base URL or API key changes.
+type Catalog struct {
+	prober  *Prober
+	ttl     time.Duration
+	mu      sync.RWMutex
+	entries map[string]catalogEntry
+}
+
+func NewCatalog(prober *Prober, ttl time.Duration) *Catalog {
+	return &Catalog{
+		prober:  prober,
+		ttl:     ttl,
+		entries: make(map[string]catalogEntry),
+	}
+}
+
+// Models returns the model list of the provider, fetching it when the cache
+// is empty, stale or refresh is set. On failure the probe result explains why.
+func (c *Catalog) Models(ctx context.Context, name string, cfg config.ProviderConfig, refresh bool) (*ModelList, *TestResult) {
+	fp := fingerprint(cfg)
+	if !refresh {
+		if entry, ok := c.lookup(name, fp); ok {
+			return &ModelList{Provider: name, Models: entry.models, FetchedAt: entry.fetchedAt, Cached: true}, nil
+		}
+	}
+
+	models, result := c.prober.ListModels(ctx, name, cfg)
+	if !result.OK {
+		return nil, result
+	}
+	sort.Strings(models)
+	if models == nil {
+		models = []string{}
+	}
+
+	entry := catalogEntry{fingerprint: fp, models: models, fetchedAt: time.Now()}
+	c.mu.Lock()
+	c.entries[name] = entry
+	c.mu.Unlock()
+
+	return &ModelList{Provider: name, Models: models, FetchedAt: entry.fetchedAt}, nil
+}
+
+// KnownModels implements config.ModelCatalog using cached lists only, so
+// validation never waits on the network.
+func (c *Catalog) KnownModels(name string, cfg config.ProviderConfig) ([]string, bool) {
+	entry, ok := c.lookup(name, fingerprint(cfg))
+	if !ok {
+		return nil, false
+	}
+	return entry.models, true
+}
+
+// Warm refreshes the lists of all providers in cfg in the background.
+func (c *Catalog) Warm(cfg *config.Config) {
+	for name, p := range cfg.Providers {
+		go func(name string, p config.ProviderConfig) {
+			ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+			defer cancel()
+			if _, result := c.Models(ctx, name, p, false); result != nil {
+				slog.Debug("Failed to list provider models", "provider", name, "error_class", result.ErrorClass, "error", result.Error)
+			}
+		}(name, p)
+	}
+}
+
+func (c *Catalog) lookup(name, fp string) (catalogEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, ok := c.entries[name]
+	if !ok || entry.fingerprint != fp || time.Since(entry.fetchedAt) > c.ttl {
+		return catalogEntry{}, false
+	}
+	return entry, true
+}
+
+func fingerprint(cfg config.ProviderConfig) string {
+	sum := sha256.Sum256([]byte(cfg.Type + "\x00" + cfg.BaseURL + "\x00" + cfg.APIKey))
+	return hex.EncodeToString(sum[:])
+}
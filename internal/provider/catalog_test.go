@@ -0,0 +1,74 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+func TestCatalogFollowsAnthropicPages(t *testing.T) {
+	pages := map[string]string{
+		"":         `{"data": [{"id": "claude-b"}, {"id": "claude-a"}], "has_more": true, "first_id": "claude-b", "last_id": "claude-a"}`,
+		"claude-a": `{"data": [{"id": "claude-d"}], "has_more": true, "first_id": "claude-d", "last_id": "claude-d"}`,
+		"claude-d": `{"data": [{"id": "claude-c"}], "has_more": false, "first_id": "claude-c", "last_id": "claude-c"}`,
+	}
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path != "/v1/models" || r.URL.Query().Get("limit") != anthropicPageSize {
+			t.Errorf("request for %s", r.URL)
+		}
+		page, ok := pages[r.URL.Query().Get("after_id")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, page)
+	}))
+	defer srv.Close()
+
+	c := NewCatalog(NewProber(DefaultTimeout), DefaultCatalogTTL)
+	cfg := config.ProviderConfig{Type: "anthropic", BaseURL: srv.URL, APIKey: "key"}
+	list, result := c.Models(context.Background(), "claude", cfg, false)
+	if result != nil {
+		t.Fatalf("Models failed: %+v", result)
+	}
+	want := []string{"claude-a", "claude-b", "claude-c", "claude-d"}
+	if !reflect.DeepEqual(list.Models, want) || list.Cached {
+		t.Errorf("Models = %v, cached %v, want %v", list.Models, list.Cached, want)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("fetched %d pages, want 3", got)
+	}
+
+	list, result = c.Models(context.Background(), "claude", cfg, false)
+	if result != nil || !list.Cached || requests.Load() != 3 {
+		t.Errorf("second Models = %+v, %+v after %d requests, want the cached list", list, result, requests.Load())
+	}
+	if models, ok := c.KnownModels("claude", cfg); !ok || !reflect.DeepEqual(models, want) {
+		t.Errorf("KnownModels = %v, %v, want %v", models, ok, want)
+	}
+	cfg.APIKey = "other"
+	if _, ok := c.KnownModels("claude", cfg); ok {
+		t.Error("KnownModels used the list cached for another API key")
+	}
+}
+
+func TestCatalogRejectsStuckPagination(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data": [{"id": "claude-a"}], "has_more": true, "last_id": "claude-a"}`)
+	}))
+	defer srv.Close()
+
+	c := NewCatalog(NewProber(DefaultTimeout), DefaultCatalogTTL)
+	cfg := config.ProviderConfig{Type: "anthropic", BaseURL: srv.URL}
+	if list, result := c.Models(context.Background(), "claude", cfg, false); result == nil || result.ErrorClass != ErrorResponse {
+		t.Errorf("Models = %+v, %+v, want an %s error", list, result, ErrorResponse)
+	}
+}
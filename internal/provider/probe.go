@@ -0,0 +1,324 @@
+package provider
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+const (
+	ErrorAuth             = "auth"
+	ErrorDNS              = "dns"
+	ErrorTLS              = "tls"
+	ErrorTimeout          = "timeout"
+	ErrorConnection       = "connection"
+	ErrorInvalidURL       = "invalid_url"
+	ErrorEndpointNotFound = "endpoint_not_found"
+	ErrorModelNotFound    = "model_not_found"
+	ErrorHTTP             = "http"
+	ErrorResponse         = "invalid_response"
+	ErrorUnsupported      = "unsupported_type"
+
+	DefaultTimeout = 10 * time.Second
+
+	maxResponseSize = 4 << 20
+	// maxModelPages bounds the pages followed for a paginated model list.
+	maxModelPages = 100
+	// anthropicPageSize is the largest page the Anthropic API returns.
+	anthropicPageSize = "1000"
+)
+
+var defaultBaseURLs = map[string]string{
+	"openai":    "https://api.openai.com/v1",
+	"anthropic": "https://api.anthropic.com",
+	"ollama":    "http://localhost:11434",
+}
+
+// TestResult describes the outcome of a single request against a provider.
+type TestResult struct {
+	Provider      string   `json:"provider"`
+	Type          string   `json:"type"`
+	URL           string   `json:"url"`
+	OK            bool     `json:"ok"`
+	StatusCode    int      `json:"status_code,omitempty"`
+	LatencyMS     int64    `json:"latency_ms"`
+	ErrorClass    string   `json:"error_class,omitempty"`
+	Error         string   `json:"error,omitempty"`
+	ModelCount    int      `json:"model_count"`
+	MissingModels []string `json:"missing_models,omitempty"`
+}
+
+// Prober talks to provider model-listing endpoints.
+type Prober struct {
+	client *http.Client
+}
+
+func NewProber(timeout time.Duration) *Prober {
+	return &Prober{client: &http.Client{Timeout: timeout}}
+}
+
+// Test lists the models of the provider and checks that every model in
+// wantModels is among them.
+func (p *Prober) Test(ctx context.Context, name string, cfg config.ProviderConfig, wantModels []string) *TestResult {
+	models, result := p.ListModels(ctx, name, cfg)
+	if !result.OK {
+		return result
+	}
+
+	available := make(map[string]bool, len(models))
+	for _, m := range models {
+		available[m] = true
+	}
+	for _, m := range wantModels {
+		if !available[m] {
+			result.MissingModels = append(result.MissingModels, m)
+		}
+	}
+	if len(result.MissingModels) > 0 {
+		result.OK = false
+		result.ErrorClass = ErrorModelNotFound
+		result.Error = fmt.Sprintf("models not offered by provider: %s", strings.Join(result.MissingModels, ", "))
+	}
+	return result
+}
+
+// ListModels performs authenticated requests against the model-listing
+// endpoint of the provider and returns the model names it reports, following
+// the pages of paginated lists.
+func (p *Prober) ListModels(ctx context.Context, name string, cfg config.ProviderConfig) ([]string, *TestResult) {
+	result := &TestResult{Provider: name, Type: cfg.Type}
+
+	var models []string
+	after := ""
+	for page := 0; ; page++ {
+		if page == maxModelPages {
+			result.ErrorClass = ErrorResponse
+			result.Error = fmt.Sprintf("model list has more than %d pages", maxModelPages)
+			return nil, result
+		}
+		pageModels, next, ok := p.listPage(ctx, cfg, after, result)
+		if !ok {
+			return nil, result
+		}
+		models = append(models, pageModels...)
+		if next == "" {
+			break
+		}
+		after = next
+	}
+
+	result.OK = true
+	result.ModelCount = len(models)
+	return models, result
+}
+
+// listPage requests the page of the model list that starts after the model
+// ID after and returns its models and the cursor of the next page, or "" on
+// the last page. On failure it fills in result and returns false.
+func (p *Prober) listPage(ctx context.Context, cfg config.ProviderConfig, after string, result *TestResult) ([]string, string, bool) {
+	req, err := modelsRequest(ctx, cfg, after)
+	if err != nil {
+		result.ErrorClass, result.Error = classify(err)
+		if result.ErrorClass == ErrorConnection {
+			result.ErrorClass = ErrorInvalidURL
+		}
+		return nil, "", false
+	}
+	if result.URL == "" {
+		result.URL = req.URL.String()
+	}
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	result.LatencyMS += time.Since(start).Milliseconds()
+	if err != nil {
+		result.ErrorClass, result.Error = classify(err)
+		return nil, "", false
+	}
+	defer resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		result.ErrorClass, result.Error = classify(err)
+		return nil, "", false
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		result.ErrorClass = ErrorAuth
+		result.Error = fmt.Sprintf("provider rejected the credentials: %s", resp.Status)
+		return nil, "", false
+	case resp.StatusCode == http.StatusNotFound:
+		result.ErrorClass = ErrorEndpointNotFound
+		result.Error = fmt.Sprintf("model listing endpoint not found, check base_url: %s", resp.Status)
+		return nil, "", false
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		result.ErrorClass = ErrorHTTP
+		result.Error = fmt.Sprintf("unexpected response: %s", resp.Status)
+		return nil, "", false
+	}
+
+	models, next, err := parseModels(cfg.Type, body)
+	if err != nil {
+		result.ErrorClass = ErrorResponse
+		result.Error = err.Error()
+		return nil, "", false
+	}
+	if next != "" && next == after {
+		result.ErrorClass = ErrorResponse
+		result.Error = "model list pagination does not advance"
+		return nil, "", false
+	}
+	return models, next, true
+}
+
+// modelsRequest builds the request for the page of the model list that starts
+// after the model ID after, or for the first page if after is "".
+func modelsRequest(ctx context.Context, cfg config.ProviderConfig, after string) (*http.Request, error) {
+	base, ok := defaultBaseURLs[cfg.Type]
+	if !ok {
+		return nil, &unsupportedTypeError{cfg.Type}
+	}
+	if cfg.BaseURL != "" {
+		base = cfg.BaseURL
+	}
+	base = strings.TrimRight(base, "/")
+
+	var endpoint string
+	switch cfg.Type {
+	case "openai":
+		endpoint = base + "/models"
+	case "anthropic":
+		if strings.HasSuffix(base, "/v1") {
+			endpoint = base + "/models"
+		} else {
+			endpoint = base + "/v1/models"
+		}
+	case "ollama":
+		endpoint = base + "/api/tags"
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, &url.Error{Op: "parse", URL: endpoint, Err: errors.New("base_url must start with http:// or https://")}
+	}
+	if cfg.Type == "anthropic" {
+		query := u.Query()
+		query.Set("limit", anthropicPageSize)
+		if after != "" {
+			query.Set("after_id", after)
+		}
+		u.RawQuery = query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Type {
+	case "openai":
+		req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+	case "anthropic":
+		req.Header.Set("x-api-key", cfg.APIKey)
+		req.Header.Set("anthropic-version", "2023-06-01")
+	case "ollama":
+		if cfg.APIKey != "" {
+			req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+		}
+	}
+	return req, nil
+}
+
+// parseModels returns the models in a page of the model list and the cursor
+// of the next page, or "" if there is none.
+func parseModels(providerType string, body []byte) ([]string, string, error) {
+	var models []string
+	var next string
+	switch providerType {
+	case "ollama":
+		var resp struct {
+			Models []struct {
+				Name string `json:"name"`
+			} `json:"models"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil, "", fmt.Errorf("failed to parse model list: %w", err)
+		}
+		for _, m := range resp.Models {
+			models = append(models, m.Name)
+		}
+	default:
+		var resp struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+			HasMore bool   `json:"has_more"`
+			LastID  string `json:"last_id"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil, "", fmt.Errorf("failed to parse model list: %w", err)
+		}
+		for _, m := range resp.Data {
+			models = append(models, m.ID)
+		}
+		if providerType == "anthropic" && resp.HasMore {
+			if resp.LastID == "" {
+				return nil, "", errors.New("failed to parse model list: has_more is set without last_id")
+			}
+			next = resp.LastID
+		}
+	}
+	return models, next, nil
+}
+
+type unsupportedTypeError struct {
+	providerType string
+}
+
+func (e *unsupportedTypeError) Error() string {
+	return fmt.Sprintf("unsupported provider type %q", e.providerType)
+}
+
+// classify maps a transport error onto one of the Error* classes.
+func classify(err error) (string, string) {
+	var unsupported *unsupportedTypeError
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+	var urlErr *url.Error
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &unsupported):
+		return ErrorUnsupported, err.Error()
+	case errors.As(err, &dnsErr):
+		return ErrorDNS, err.Error()
+	case errors.As(err, &certErr), errors.As(err, &unknownAuthority), errors.As(err, &hostnameErr),
+		errors.As(err, &invalidCert), errors.As(err, &recordErr):
+		return ErrorTLS, err.Error()
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout, err.Error()
+	case errors.As(err, &urlErr) && urlErr.Op == "parse":
+		return ErrorInvalidURL, err.Error()
+	default:
+		return ErrorConnection, err.Error()
+	}
+}
@@ -0,0 +1,137 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yockii/wangshu-manager/internal/config"
+)
+
+func TestListModelsClassifiesResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		class  string
+	}{
+		{"unauthorized", http.StatusUnauthorized, `{"error": "invalid key"}`, ErrorAuth},
+		{"forbidden", http.StatusForbidden, `{"error": "no access"}`, ErrorAuth},
+		{"not found", http.StatusNotFound, `404 page not found`, ErrorEndpointNotFound},
+		{"server error", http.StatusInternalServerError, `oops`, ErrorHTTP},
+		{"bad json", http.StatusOK, `<html>not json</html>`, ErrorResponse},
+		{"ok", http.StatusOK, `{"data": [{"id": "gpt-4o"}]}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer srv.Close()
+
+			cfg := config.ProviderConfig{Type: "openai", BaseURL: srv.URL, APIKey: "key"}
+			_, result := NewProber(DefaultTimeout).ListModels(context.Background(), "p", cfg)
+			if result.ErrorClass != tt.class || result.OK != (tt.class == "") || result.StatusCode != tt.status {
+				t.Errorf("ListModels = %+v, want class %q and status %d", result, tt.class, tt.status)
+			}
+			if result.URL != srv.URL+"/models" {
+				t.Errorf("URL = %q, want %q", result.URL, srv.URL+"/models")
+			}
+		})
+	}
+}
+
+func TestListModelsTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	cfg := config.ProviderConfig{Type: "ollama", BaseURL: srv.URL}
+	_, result := NewProber(50*time.Millisecond).ListModels(context.Background(), "p", cfg)
+	if result.OK || result.ErrorClass != ErrorTimeout {
+		t.Errorf("ListModels = %+v, want a %s error", result, ErrorTimeout)
+	}
+}
+
+func TestListModelsRequestErrors(t *testing.T) {
+	tests := []struct {
+		cfg   config.ProviderConfig
+		class string
+	}{
+		{config.ProviderConfig{Type: "gemini"}, ErrorUnsupported},
+		{config.ProviderConfig{Type: "openai", BaseURL: "ftp://example.com"}, ErrorInvalidURL},
+		{config.ProviderConfig{Type: "openai", BaseURL: "http://[::1"}, ErrorInvalidURL},
+	}
+	for _, tt := range tests {
+		_, result := NewProber(DefaultTimeout).ListModels(context.Background(), "p", tt.cfg)
+		if result.OK || result.ErrorClass != tt.class {
+			t.Errorf("ListModels(%+v) = %+v, want a %s error", tt.cfg, result, tt.class)
+		}
+	}
+}
+
+func TestListModelsParsesProviders(t *testing.T) {
+	tests := []struct {
+		providerType string
+		path         string
+		header       string
+		value        string
+		body         string
+		want         []string
+	}{
+		{"openai", "/models", "Authorization", "Bearer key",
+			`{"object": "list", "data": [{"id": "gpt-4o", "object": "model"}, {"id": "gpt-4o-mini", "object": "model"}]}`,
+			[]string{"gpt-4o", "gpt-4o-mini"}},
+		{"anthropic", "/v1/models", "x-api-key", "key",
+			`{"data": [{"id": "claude-sonnet-4", "type": "model"}], "has_more": false, "first_id": "claude-sonnet-4", "last_id": "claude-sonnet-4"}`,
+			[]string{"claude-sonnet-4"}},
+		{"ollama", "/api/tags", "Authorization", "Bearer key",
+			`{"models": [{"name": "qwen3:8b", "size": 5000000000}, {"name": "llama3:latest"}]}`,
+			[]string{"qwen3:8b", "llama3:latest"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.providerType, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tt.path {
+					http.NotFound(w, r)
+					return
+				}
+				if got := r.Header.Get(tt.header); got != tt.value {
+					t.Errorf("%s header = %q, want %q", tt.header, got, tt.value)
+				}
+				fmt.Fprint(w, tt.body)
+			}))
+			defer srv.Close()
+
+			cfg := config.ProviderConfig{Type: tt.providerType, BaseURL: srv.URL, APIKey: "key"}
+			models, result := NewProber(DefaultTimeout).ListModels(context.Background(), "p", cfg)
+			if !result.OK || !reflect.DeepEqual(models, tt.want) || result.ModelCount != len(tt.want) {
+				t.Errorf("ListModels = %v, %+v, want %v", models, result, tt.want)
+			}
+		})
+	}
+}
+
+func TestProberReportsMissingModels(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data": [{"id": "gpt-4o"}]}`)
+	}))
+	defer srv.Close()
+
+	cfg := config.ProviderConfig{Type: "openai", BaseURL: srv.URL + "/"}
+	result := NewProber(DefaultTimeout).Test(context.Background(), "p", cfg, []string{"gpt-4o", "gpt-5"})
+	if result.OK || result.ErrorClass != ErrorModelNotFound || !reflect.DeepEqual(result.MissingModels, []string{"gpt-5"}) {
+		t.Errorf("Test = %+v, want gpt-5 to be missing", result)
+	}
+}
@@ -0,0 +1,8 @@
+// Package static holds the built-in web frontend, which is embedded into the
+// manager binary.
+package static
+
+import "embed"
+
+//go:embed index.html js
+var FS embed.FS
@@ -0,0 +1,69 @@
+// 登录后使用 HttpOnly 会话 Cookie 认证，URL 中不再保留 token。
+// 修改类请求需要在 X-CSRF-Token 头中带上登录时返回的 CSRF token。
+let csrfToken = '';
+
+// 接口响应成功时为 { data }，失败时为 { error: { code, message, details } }。
+const API_BASE = '/api/v1';
+
+function unwrapEnvelope(body) {
+    return body && body.data !== undefined ? body.data : body;
+}
+
+$.ajaxSetup({
+    beforeSend: function(xhr, settings) {
+        if (csrfToken && settings.type !== 'GET') {
+            xhr.setRequestHeader('X-CSRF-Token', csrfToken);
+        }
+    },
+    converters: {
+        'text json': text => unwrapEnvelope(JSON.parse(text))
+    }
+});
+
+function apiFetch(url, options = {}) {
+    const headers = Object.assign({}, options.headers);
+    if (csrfToken && options.method && options.method !== 'GET') {
+        headers['X-CSRF-Token'] = csrfToken;
+    }
+    return fetch(url, Object.assign({}, options, { headers: headers, credentials: 'same-origin' }));
+}
+
+// readAPIResponse 返回响应中的 data，失败时抛出带服务端错误信息的 Error。
+function readAPIResponse(response) {
+    return response.json().catch(() => ({})).then(body => {
+        if (!response.ok) {
+            const error = new Error((body.error && body.error.message) || response.statusText);
+            error.status = response.status;
+            error.code = body.error && body.error.code;
+            throw error;
+        }
+        return unwrapEnvelope(body);
+    });
+}
+
+function handleSessionResponse(response) {
+    return readAPIResponse(response).then(data => {
+        csrfToken = data.csrf_token || '';
+        return data;
+    }, error => {
+        throw new Error(error.status === 401 ? '凭据无效' : '登录失败');
+    });
+}
+
+// credentials 为 { token } 或 { username, password }
+function login(credentials) {
+    return fetch(`${API_BASE}/login`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(credentials),
+        credentials: 'same-origin'
+    }).then(handleSessionResponse);
+}
+
+function checkSession() {
+    return fetch(`${API_BASE}/session`, { credentials: 'same-origin' }).then(handleSessionResponse);
+}
+
+function logout() {
+    apiFetch(`${API_BASE}/logout`, { method: 'POST' }).finally(() => window.location.reload());
+}
@@ -1,9 +1,36 @@
 let currentConfig = null;
+let channelTypes = [];
+
+function loadChannelTypes(callback) {
+    $.ajax({
+        url: `${API_BASE}/config/channel-types`,
+        method: 'GET',
+        success: function(response) {
+            channelTypes = response.channelTypes || [];
+            callback();
+        },
+        error: function(xhr, status, error) {
+            console.error('Failed to load channel types:', error);
+            callback();
+        }
+    });
+}
+
+function findChannelType(name) {
+    return channelTypes.find(t => t.name === name);
+}
 
 function loadConfig() {
-    const token = new URLSearchParams(window.location.search).get('token') || 'default';
+    if (channelTypes.length === 0) {
+        loadChannelTypes(fetchConfig);
+        return;
+    }
+    fetchConfig();
+}
+
+function fetchConfig() {
     $.ajax({
-        url: `/api/config?token=${token}`,
+        url: `${API_BASE}/config`,
         method: 'GET',
         success: function(response) {
             currentConfig = response.config;
@@ -156,12 +183,19 @@ function renderChannelConfig(channelKey, channel) {
     const container = $(`#channel${channelKey} .channel-config-form`);
     let html = '<div class="config-form">';
     
-    const channelTypes = ['web', 'feishu'];
+    const typeNames = channelTypes.map(t => t.name);
+    if (channel.type && !typeNames.includes(channel.type)) {
+        typeNames.push(channel.type);
+    }
     html += `
         <div class="form-group">
             <label>Type</label>
             <select class="channel-type" data-channel-key="${channelKey}">
-                ${channelTypes.map(t => `<option value="${t}" ${channel.type === t ? 'selected' : ''}>${t.charAt(0).toUpperCase() + t.slice(1)}</option>`).join('')}
+                ${typeNames.map(t => {
+                    const info = findChannelType(t);
+                    const title = info ? info.title : t;
+                    return `<option value="${t}" ${channel.type === t ? 'selected' : ''}>${title}</option>`;
+                }).join('')}
             </select>
         </div>
         <div class="form-group">
@@ -186,29 +220,10 @@ function renderChannelConfig(channelKey, channel) {
         </div>
     `;
     
-    if (channel.type === 'feishu') {
-        html += `
-            <div class="form-group">
-                <label>App ID</label>
-                <input type="text" class="channel-app-id" value="${channel.app_id || ''}">
-            </div>
-            <div class="form-group">
-                <label>App Secret</label>
-                <input type="text" class="channel-app-secret" value="${channel.app_secret || ''}">
-            </div>
-        `;
-    } else if (channel.type === 'web') {
-        html += `
-            <div class="form-group">
-                <label>Host Address</label>
-                <input type="text" class="channel-host-address" value="${channel.host_address || ''}" placeholder="localhost:8080">
-            </div>
-            <div class="form-group">
-                <label>Token</label>
-                <input type="text" class="channel-token" value="${channel.token || ''}">
-            </div>
-        `;
-    }
+    const typeInfo = findChannelType(channel.type);
+    (typeInfo ? typeInfo.fields : []).forEach(field => {
+        html += renderChannelField(field, channel[field.name]);
+    });
     
     html += '</div>';
     container.html(html);
@@ -220,6 +235,50 @@ function renderChannelConfig(channelKey, channel) {
     });
 }
 
+function renderChannelField(field, value) {
+    const label = field.name.split('_').map(w => w.charAt(0).toUpperCase() + w.slice(1)).join(' ') + (field.required ? ' *' : '');
+    const placeholder = field.default !== undefined ? field.default : (field.description || '');
+    const attrs = `class="channel-field" data-field="${field.name}" data-field-type="${field.type}" title="${field.description || ''}"`;
+    let input;
+    
+    if (field.type === 'boolean') {
+        const checked = value === undefined ? field.default === true : value === true;
+        input = `<select ${attrs}>
+                <option value="true" ${checked ? 'selected' : ''}>是</option>
+                <option value="false" ${!checked ? 'selected' : ''}>否</option>
+            </select>`;
+    } else if (field.type === 'array') {
+        input = `<input type="text" ${attrs} value="${(value || []).join(', ')}" placeholder="${placeholder}">`;
+    } else if (field.type === 'number' || field.type === 'integer') {
+        input = `<input type="number" ${attrs} value="${value !== undefined ? value : ''}" placeholder="${placeholder}">`;
+    } else {
+        input = `<input type="${field.secret ? 'password' : 'text'}" ${attrs} value="${value || ''}" placeholder="${placeholder}">`;
+    }
+    
+    return `
+        <div class="form-group">
+            <label>${label}</label>
+            ${input}
+        </div>
+    `;
+}
+
+function readChannelField(input) {
+    const value = input.val();
+    switch (input.data('field-type')) {
+        case 'boolean':
+            return value === 'true';
+        case 'array':
+            return value.split(',').map(v => v.trim()).filter(v => v !== '');
+        case 'number':
+            return value === '' ? undefined : parseFloat(value);
+        case 'integer':
+            return value === '' ? undefined : parseInt(value, 10);
+        default:
+            return value;
+    }
+}
+
 function renderSkill() {
     if (!currentConfig.skill) return;
     
@@ -239,8 +298,6 @@ function renderSkill() {
 }
 
 function saveConfig() {
-    const token = new URLSearchParams(window.location.search).get('token') || 'default';
-    
     const newConfig = {
         agents: {},
         providers: {},
@@ -278,12 +335,19 @@ function saveConfig() {
             agent: $(this).find('.channel-agent').val()
         };
         
-        if (channelType === 'feishu') {
-            channelData.app_id = $(this).find('.channel-app-id').val();
-            channelData.app_secret = $(this).find('.channel-app-secret').val();
-        } else if (channelType === 'web') {
-            channelData.host_address = $(this).find('.channel-host-address').val();
-            channelData.token = $(this).find('.channel-token').val();
+        if (findChannelType(channelType)) {
+            $(this).find('.channel-field').each(function() {
+                const value = readChannelField($(this));
+                if (value !== undefined) {
+                    channelData[$(this).data('field')] = value;
+                }
+            });
+        } else {
+            // 未注册的类型原样保留其余字段
+            const original = currentConfig.channels[channelKey] || {};
+            Object.keys(original).forEach(k => {
+                if (!(k in channelData)) channelData[k] = original[k];
+            });
         }
         
         newConfig.channels[channelKey] = channelData;
@@ -295,16 +359,31 @@ function saveConfig() {
     };
     
     $.ajax({
-        url: `/api/config?token=${token}`,
+        url: `${API_BASE}/config`,
         method: 'PUT',
         contentType: 'application/json',
         data: JSON.stringify(newConfig),
         success: function(response) {
-            alert('配置保存成功！');
+            const warnings = ((response.validation && response.validation.issues) || [])
+                .map(issue => `${issue.path}: ${issue.message}`);
+            if (warnings.length > 0) {
+                alert('配置保存成功，但存在以下警告：\n' + warnings.join('\n'));
+            } else {
+                alert('配置保存成功！');
+            }
             loadConfig();
         },
         error: function(xhr, status, error) {
-            alert('配置保存失败：' + error);
+            const apiError = xhr.responseJSON && xhr.responseJSON.error;
+            const validation = apiError && apiError.details && apiError.details.validation;
+            if (validation && validation.issues) {
+                const messages = validation.issues
+                    .filter(issue => issue.severity === 'error')
+                    .map(issue => `${issue.path}: ${issue.message}`);
+                alert('配置校验失败：\n' + messages.join('\n'));
+                return;
+            }
+            alert('配置保存失败：' + ((apiError && apiError.message) || error));
         }
     });
 }
@@ -368,8 +447,10 @@ function addChannel() {
         return;
     }
     
-    const type = prompt('请输入 Channel 类型 (web/feishu)：', 'web');
-    if (type !== 'web' && type !== 'feishu') {
+    const typeNames = channelTypes.map(t => t.name);
+    const type = prompt(`请输入 Channel 类型 (${typeNames.join('/')})：`, typeNames[0] || 'web');
+    const typeInfo = findChannelType(type);
+    if (!typeInfo) {
         alert('无效的 Channel 类型');
         return;
     }
@@ -382,13 +463,11 @@ function addChannel() {
         agent: defaultAgent
     };
     
-    if (type === 'feishu') {
-        currentConfig.channels[key].app_id = '';
-        currentConfig.channels[key].app_secret = '';
-    } else if (type === 'web') {
-        currentConfig.channels[key].host_address = 'localhost:8080';
-        currentConfig.channels[key].token = '';
-    }
+    typeInfo.fields.forEach(field => {
+        if (field.default !== undefined) {
+            currentConfig.channels[key][field.name] = field.default;
+        }
+    });
     
     renderChannels();
 }
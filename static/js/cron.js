@@ -21,10 +21,8 @@ function loadCronJobs() {
         return;
     }
     
-    const token = new URLSearchParams(window.location.search).get('token') || 'default';
-    
     $.ajax({
-        url: `/api/cron?token=${token}&agent=${agentKey}`,
+        url: `${API_BASE}/cron?agent=${agentKey}`,
         method: 'GET',
         success: function(response) {
             renderCronJobs(response.cronJobs || []);
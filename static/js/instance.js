@@ -1,19 +1,8 @@
 let instanceStatusInterval = null;
 
 function loadInstanceStatus() {
-    const token = new URLSearchParams(window.location.search).get('token') || 'default';
-    if (!token) {
-        showAuthError();
-        return;
-    }
-
-    fetch(`/api/instance?token=${encodeURIComponent(token)}`)
-        .then(response => {
-            if (!response.ok) {
-                throw new Error('Failed to load instance status');
-            }
-            return response.json();
-        })
+    apiFetch(`${API_BASE}/instance`)
+        .then(readAPIResponse)
         .then(data => {
             updateInstanceUI(data.status);
         })
@@ -86,12 +75,6 @@ function updateInstanceUI(status) {
 }
 
 function startInstance() {
-    const token = new URLSearchParams(window.location.search).get('token') || 'default';
-    if (!token) {
-        showAuthError();
-        return;
-    }
-
     if (!confirm('确定要启动望舒实例吗？')) {
         return;
     }
@@ -99,15 +82,10 @@ function startInstance() {
     document.getElementById('startBtn').disabled = true;
     document.getElementById('startBtn').textContent = '启动中...';
 
-    fetch(`/api/instance?action=start&token=${encodeURIComponent(token)}`, {
+    apiFetch(`${API_BASE}/instance?action=start`, {
         method: 'POST'
     })
-    .then(response => {
-        if (!response.ok) {
-            throw new Error('Failed to start instance');
-        }
-        return response.json();
-    })
+    .then(readAPIResponse)
     .then(data => {
         alert(data.message || '实例启动成功');
         loadInstanceStatus();
@@ -121,12 +99,6 @@ function startInstance() {
 }
 
 function stopInstance() {
-    const token = new URLSearchParams(window.location.search).get('token') || 'default';
-    if (!token) {
-        showAuthError();
-        return;
-    }
-
     if (!confirm('确定要停止望舒实例吗？')) {
         return;
     }
@@ -134,15 +106,10 @@ function stopInstance() {
     document.getElementById('stopBtn').disabled = true;
     document.getElementById('stopBtn').textContent = '停止中...';
 
-    fetch(`/api/instance?action=stop&token=${encodeURIComponent(token)}`, {
+    apiFetch(`${API_BASE}/instance?action=stop`, {
         method: 'POST'
     })
-    .then(response => {
-        if (!response.ok) {
-            throw new Error('Failed to stop instance');
-        }
-        return response.json();
-    })
+    .then(readAPIResponse)
     .then(data => {
         alert(data.message || '实例停止成功');
         loadInstanceStatus();
@@ -156,12 +123,6 @@ function stopInstance() {
 }
 
 function restartInstance() {
-    const token = new URLSearchParams(window.location.search).get('token') || 'default';
-    if (!token) {
-        showAuthError();
-        return;
-    }
-
     if (!confirm('确定要重启望舒实例吗？')) {
         return;
     }
@@ -169,15 +130,10 @@ function restartInstance() {
     document.getElementById('restartBtn').disabled = true;
     document.getElementById('restartBtn').textContent = '重启中...';
 
-    fetch(`/api/instance?action=restart&token=${encodeURIComponent(token)}`, {
+    apiFetch(`${API_BASE}/instance?action=restart`, {
         method: 'POST'
     })
-    .then(response => {
-        if (!response.ok) {
-            throw new Error('Failed to restart instance');
-        }
-        return response.json();
-    })
+    .then(readAPIResponse)
     .then(data => {
         alert(data.message || '实例重启成功');
         loadInstanceStatus();
@@ -1,24 +1,39 @@
 let ws = null;
 let wangshuConnected = false;
 let isConnecting = false;
-const token = new URLSearchParams(window.location.search).get('token') || '';
 
-// 检查是否有token
-if (!token) {
-    document.querySelector('.container').style.display = 'none';
-    document.getElementById('authError').style.display = 'flex';
-} else {
+function showApp() {
     document.querySelector('.container').style.display = 'block';
     document.getElementById('authError').style.display = 'none';
     connect();
     loadConfig();
 }
 
+function showLogin() {
+    document.querySelector('.container').style.display = 'none';
+    document.getElementById('authError').style.display = 'flex';
+}
+
+// 旧链接中的 token 换成会话 Cookie 后从地址栏移除，避免留在浏览记录里
+function initAuth() {
+    const urlToken = new URLSearchParams(window.location.search).get('token');
+    const ready = urlToken
+        ? login({ token: urlToken }).finally(() => history.replaceState(null, '', window.location.pathname))
+        : checkSession();
+    ready.then(showApp).catch(showLogin);
+}
+
 function submitToken() {
     const token = document.getElementById('tokenInput').value.trim();
-    if (token) {
-        window.location.href = `?token=${token}`;
-    }
+    if (!token) return;
+    login({ token: token }).then(showApp).catch(error => alert(error.message));
+}
+
+function submitPassword() {
+    const username = document.getElementById('usernameInput').value.trim();
+    const password = document.getElementById('passwordInput').value;
+    if (!username || !password) return;
+    login({ username: username, password: password }).then(showApp).catch(error => alert(error.message));
 }
 
 function connect() {
@@ -30,7 +45,7 @@ function connect() {
     isConnecting = true;
     
     const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-    const wsUrl = `${protocol}//${window.location.host}/webWs?token=${token}`;
+    const wsUrl = `${protocol}//${window.location.host}/webWs`;
     
     $('#wsStatus').removeClass('connected disconnected').addClass('connecting');
     $('#wsStatus span').text('服务端: 连接中...');
@@ -140,8 +155,8 @@ $('#messageInput').keypress(function(e) {
     }
 });
 
-$('.nav button').click(function() {
-    $('.nav button').removeClass('active');
+$('.nav button[data-tab]').click(function() {
+    $('.nav button[data-tab]').removeClass('active');
     $(this).addClass('active');
     
     $('.content').removeClass('active');
@@ -149,9 +164,5 @@ $('.nav button').click(function() {
 });
 
 $(document).ready(function() {
-    // 只有在有token时才初始化
-    if (token) {
-        connect();
-        loadConfig();
-    }
+    initAuth();
 });
\ No newline at end of file
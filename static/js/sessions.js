@@ -21,10 +21,8 @@ function loadSessions() {
         return;
     }
     
-    const token = new URLSearchParams(window.location.search).get('token') || 'default';
-    
     $.ajax({
-        url: `/api/sessions?token=${token}&agent=${agentKey}`,
+        url: `${API_BASE}/sessions?agent=${agentKey}`,
         method: 'GET',
         success: function(response) {
             renderSessions(response.sessions || []);
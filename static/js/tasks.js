@@ -21,10 +21,8 @@ function loadTasks() {
         return;
     }
     
-    const token = new URLSearchParams(window.location.search).get('token') || 'default';
-    
     $.ajax({
-        url: `/api/tasks?token=${token}&agent=${agentKey}`,
+        url: `${API_BASE}/tasks?agent=${agentKey}`,
         method: 'GET',
         success: function(response) {
             renderTasks(response.tasks || []);